@@ -0,0 +1,232 @@
+package btree
+
+// BPlusTree is a variant of BTree which holds every value in its leaves. The
+// keys of internal nodes are copies of keys, kept only to route searches to
+// the leaf in which a key belongs, and the leaves are chained together in
+// order. A range scan finds its first key in a single descent, and then walks
+// the chain of leaves without returning to the internal nodes.
+//
+// The tree is built from the same nodes as a BTree, and splits, merges and
+// shuffles its internal nodes in the same way, while its leaves are split by
+// copying the first key of the new sibling up as a routing key, rather than
+// moving the median up. As in a BTree, each node holds between t-1 and 2t-1
+// keys, with the root allowed fewer. Keys which compare equal are treated as
+// the same key. The counts of keys beneath internal nodes aren't kept, as
+// routing keys aren't keys of the tree.
+type BPlusTree[T Comparable[T]] struct {
+	root childNode[T]
+	cfg  *config[T]
+	size int
+}
+
+// NewBPlusTree creates an empty BPlusTree with the default degree.
+func NewBPlusTree[T Comparable[T]]() *BPlusTree[T] {
+	return NewBPlusTreeWithOptions(Options[T]{})
+}
+
+// NewBPlusTreeWithOptions creates an empty BPlusTree with the branching factor
+// opts.Degree, which as for a BTree is the default degree if zero, and must
+// otherwise be greater than 2. The leaves are always linked, and the other
+// options, which apply to a BTree alone, are ignored.
+func NewBPlusTreeWithOptions[T Comparable[T]](opts Options[T]) *BPlusTree[T] {
+	cfg := newConfig(T.Compare, Options[T]{Degree: opts.Degree, LinkLeaves: true})
+	return &BPlusTree[T]{root: newChildLeafNode(cfg), cfg: cfg}
+}
+
+// Len returns the number of keys in the tree.
+func (b BPlusTree[T]) Len() int {
+	return b.size
+}
+
+// Search searches the tree for the value matching key if such a value exists.
+func (b BPlusTree[T]) Search(key T) (T, bool) {
+	leaf := b.leafFor(key)
+	if i, found := find(leaf.keys, key, b.cfg.compare); found {
+		return leaf.keys[i], true
+	}
+	var zero T
+	return zero, false
+}
+
+// Insert inserts key into the tree or updates an existing value matching key
+// if such a value exists. Like BTree, the insertion descends the tree in a
+// single pass, splitting full nodes on the way down.
+func (b *BPlusTree[T]) Insert(key T) {
+	if !b.root.isBelowMax() {
+		root := newChildInternalNode(b.cfg)
+		root.children.insert(0, b.root)
+		b.splitChild(root, 0)
+		b.root = root
+	}
+	if b.insertBelowMax(b.root, key) {
+		b.size++
+	}
+}
+
+// Remove removes the value matching key from the tree if such a value exists.
+// Routing keys matching key are left in place, as they still separate the keys
+// either side of them.
+func (b *BPlusTree[T]) Remove(key T) {
+	if b.remove(b.root, key) {
+		b.size--
+	}
+	if root, ok := b.root.(*childInternalNode[T]); ok && len(root.keys) == 0 {
+		b.root = root.children[0]
+	}
+}
+
+// Ascend calls fn for each key in the tree in ascending order, until fn returns
+// false, by walking the chain of leaves.
+func (b BPlusTree[T]) Ascend(fn func(T) bool) {
+	ascendLeaves(b.root.firstLeaf(), 0, fn)
+}
+
+// AscendRange calls fn for each key k in the range from ≤ k < to in ascending
+// order, until fn returns false. The leaf holding from is found in a single
+// descent, after which the walk follows the chain of leaves.
+func (b BPlusTree[T]) AscendRange(from, to T, fn func(T) bool) {
+	leaf := b.leafFor(from)
+	i, _ := find(leaf.keys, from, b.cfg.compare)
+	ascendLeaves(leaf, i, func(k T) bool {
+		return b.cfg.compare(k, to) < 0 && fn(k)
+	})
+}
+
+// leafFor returns the leaf of the tree in which k belongs.
+func (b BPlusTree[T]) leafFor(k T) *childLeafNode[T] {
+	n := b.root
+	for {
+		internal, ok := n.(*childInternalNode[T])
+		if !ok {
+			return n.(*childLeafNode[T])
+		}
+		n = internal.children[b.route(internal, k)]
+	}
+}
+
+// route returns the index of the child of the internal node n in which k
+// belongs, that to the right of any routing key matching k.
+func (b BPlusTree[T]) route(n *childInternalNode[T], k T) int {
+	i, _ := find(n.keys, k, b.cfg.after)
+	return i
+}
+
+// ascendLeaves calls fn on each key from the i-th key of leaf onwards,
+// following the chain into the leaves after it, until fn returns false.
+func ascendLeaves[T any](leaf *childLeafNode[T], i int, fn func(T) bool) {
+	for ; leaf != nil; leaf, i = leaf.next, 0 {
+		for _, k := range leaf.keys[i:] {
+			if !fn(k) {
+				return
+			}
+		}
+	}
+}
+
+// insertBelowMax inserts k into the subtree rooted at the non-full node n,
+// reporting whether k was added rather than replacing an existing value.
+func (b *BPlusTree[T]) insertBelowMax(n childNode[T], k T) bool {
+	if leaf, ok := n.(*childLeafNode[T]); ok {
+		i, found := find(leaf.keys, k, b.cfg.compare)
+		if found {
+			leaf.put(i, k)
+			return false
+		}
+		leaf.insertAt(i, item[T]{key: k})
+		return true
+	}
+	internal := n.(*childInternalNode[T])
+	i := b.route(internal, k)
+	if !internal.children[i].isBelowMax() {
+		b.splitChild(internal, i)
+		if b.cfg.compare(k, internal.keys[i]) >= 0 {
+			i++
+		}
+	}
+	return b.insertBelowMax(internal.children[i], k)
+}
+
+// splitChild splits the full i-th child of n in two. A leaf keeps its first
+// t-1 keys, and a copy of the first key of its new sibling becomes the routing
+// key between them. An internal node is split about its median key, which
+// moves up into n as in a BTree.
+func (b *BPlusTree[T]) splitChild(n *childInternalNode[T], i int) {
+	median, sibling := n.children[i].split()
+	if leaf, ok := sibling.(*childLeafNode[T]); ok {
+		leaf.insertAt(0, median)
+	}
+	n.insertAt(i, median)
+	n.children.insert(i+1, sibling)
+}
+
+// remove removes k from the subtree rooted at n, reporting whether it was
+// found. As in a BTree, the removal descends in a single pass, filling any
+// child holding the fewest keys allowed before descending into it.
+func (b *BPlusTree[T]) remove(n childNode[T], k T) bool {
+	if leaf, ok := n.(*childLeafNode[T]); ok {
+		i, found := find(leaf.keys, k, b.cfg.compare)
+		if found {
+			leaf.removeAt(i)
+		}
+		return found
+	}
+	internal := n.(*childInternalNode[T])
+	i := b.route(internal, k)
+	if !internal.children[i].isAboveMin() {
+		i = b.fill(internal, i)
+	}
+	return b.remove(internal.children[i], k)
+}
+
+// fill gives the i-th child of n, which holds t-1 keys, a key from one of its
+// siblings or else merges it with one, returning the index of the child which
+// then covers the keys of the original. A leaf takes the key itself, with the
+// routing key between the two leaves updated to the first key of the right
+// one, while an internal node takes the routing key of n as in a BTree.
+func (b *BPlusTree[T]) fill(n *childInternalNode[T], i int) int {
+	child := n.children[i]
+	leaf, isLeaf := child.(*childLeafNode[T])
+	switch {
+	case i > 0 && n.children[i-1].isAboveMin():
+		left := n.children[i-1]
+		if isLeaf {
+			from := left.(*childLeafNode[T])
+			leaf.insertAt(0, from.removeAt(len(from.keys)-1))
+			n.put(i-1, leaf.keys[0])
+		} else {
+			n.set(i-1, child.shuffleRight(n.at(i-1), left))
+		}
+		return i
+	case i < len(n.keys) && n.children[i+1].isAboveMin():
+		right := n.children[i+1]
+		if isLeaf {
+			from := right.(*childLeafNode[T])
+			leaf.insertAt(len(leaf.keys), from.removeAt(0))
+			n.put(i, from.keys[0])
+		} else {
+			n.set(i, child.shuffleLeft(n.at(i), right))
+		}
+		return i
+	case i > 0:
+		b.merge(n, i-1)
+		return i - 1
+	}
+	b.merge(n, i)
+	return i
+}
+
+// merge merges the i-th child of n with its right sibling. The routing key
+// between two leaves is dropped, with the first key of the right leaf standing
+// in its place as the median of the merge, while that between two internal
+// nodes moves down between their keys as in a BTree.
+func (b *BPlusTree[T]) merge(n *childInternalNode[T], i int) {
+	var (
+		left   = n.children[i]
+		right  = n.children.remove(i + 1)
+		median = n.removeAt(i)
+	)
+	if leaf, ok := right.(*childLeafNode[T]); ok {
+		median = leaf.removeAt(0)
+	}
+	left.merge(median, right)
+}
@@ -0,0 +1,168 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// validateBPlus fails the test unless b satisfies every invariant of a
+// B+ tree, returning the keys held by its leaves in order. Each node must hold
+// between t-1 and 2t-1 keys, or fewer for the root, with every key of each
+// child no less than the routing key before it and less than that after it.
+// All leaves must lie at the same depth, chained together in order.
+func validateBPlus(t *testing.T, b *BPlusTree[key]) []key {
+	t.Helper()
+	var (
+		leaves []*childLeafNode[key]
+		depth  = -1
+		walk   func(n childNode[key], d int, lo, hi *key)
+	)
+	walk = func(n childNode[key], d int, lo, hi *key) {
+		keys, children := n.contents()
+		if len(keys) > 2*b.cfg.t-1 || (n != b.root && len(keys) < b.cfg.t-1) {
+			t.Fatalf("node at depth %d holds %d keys", d, len(keys))
+		}
+		for i, k := range keys {
+			if (i > 0 && keys[i-1] >= k) || (lo != nil && k < *lo) || (hi != nil && k >= *hi) {
+				t.Fatalf("key %d of node at depth %d is out of order", i, d)
+			}
+		}
+		if children == nil {
+			if depth < 0 {
+				depth = d
+			} else if d != depth {
+				t.Fatalf("leaf at depth %d, while others are at depth %d", d, depth)
+			}
+			leaves = append(leaves, n.(*childLeafNode[key]))
+			return
+		}
+		if len(children) != len(keys)+1 {
+			t.Fatalf("node at depth %d has %d children for %d keys", d, len(children), len(keys))
+		}
+		for i, child := range children {
+			clo, chi := lo, hi
+			if i > 0 {
+				clo = &keys[i-1]
+			}
+			if i < len(keys) {
+				chi = &keys[i]
+			}
+			walk(child, d+1, clo, chi)
+		}
+	}
+	walk(b.root, 0, nil, nil)
+
+	var keys []key
+	for i, leaf := range leaves {
+		var prev, next *childLeafNode[key]
+		if i > 0 {
+			prev = leaves[i-1]
+		}
+		if i < len(leaves)-1 {
+			next = leaves[i+1]
+		}
+		if leaf.prev != prev || leaf.next != next {
+			t.Fatalf("leaf %d isn't linked to the leaves either side of it", i)
+		}
+		keys = append(keys, leaf.keys...)
+	}
+	if len(keys) != b.Len() {
+		t.Fatalf("tree holds %d keys but records %d", len(keys), b.Len())
+	}
+	return keys
+}
+
+func TestBPlusTree(t *testing.T) {
+	for _, degree := range []int{3, 4, 0} {
+		var (
+			b    = NewBPlusTreeWithOptions(Options[key]{Degree: degree})
+			held = map[key]bool{}
+			r    = rand.New(rand.NewSource(4))
+		)
+		// want returns the keys of held in the range lo ≤ k < hi in order.
+		want := func(lo, hi key) []key {
+			var keys []key
+			for k := lo; k < hi; k++ {
+				if held[k] {
+					keys = append(keys, k)
+				}
+			}
+			return keys
+		}
+		for i := 0; i < 20000; i++ {
+			k := key(r.Intn(2000))
+			if r.Intn(5) < 2 {
+				b.Remove(k)
+				delete(held, k)
+			} else {
+				b.Insert(k)
+				held[k] = true
+			}
+			if i%1000 == 0 {
+				if got := validateBPlus(t, b); !equal(got, want(0, 2000)) {
+					t.Fatalf("tree holds %v, want %v", got, want(0, 2000))
+				}
+			}
+		}
+
+		var got []key
+		b.Ascend(func(k key) bool {
+			got = append(got, k)
+			return true
+		})
+		if !equal(got, want(0, 2000)) {
+			t.Fatalf("Ascend visited %v, want %v", got, want(0, 2000))
+		}
+		got = got[:0]
+		b.AscendRange(500, 1500, func(k key) bool {
+			got = append(got, k)
+			return true
+		})
+		if !equal(got, want(500, 1500)) {
+			t.Fatalf("AscendRange visited %v, want %v", got, want(500, 1500))
+		}
+		for k := key(0); k < 2000; k++ {
+			if v, found := b.Search(k); found != held[k] || (found && v != k) {
+				t.Fatalf("Search(%d) = %v, %v", k, v, found)
+			}
+		}
+		for k := key(0); k < 2000; k++ {
+			b.Remove(k)
+		}
+		if keys := validateBPlus(t, b); len(keys) != 0 {
+			t.Fatalf("tree holds %v after removing every key", keys)
+		}
+	}
+}
+
+// benchmarkRangeScan times scans over ranges of 1,000 keys, through scan, of a
+// tree of 200,000 keys built by insert.
+func benchmarkRangeScan(b *testing.B, insert func(key), scan func(from, to key, fn func(key) bool)) {
+	for i := 0; i < 200000; i++ {
+		insert(key(i))
+	}
+	r := rand.New(rand.NewSource(5))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		from := key(r.Intn(199000))
+		scan(from, from+1000, func(key) bool { return true })
+	}
+}
+
+func BenchmarkBPlusTreeRangeScan(b *testing.B) {
+	tree := NewBPlusTreeWithOptions(Options[key]{Degree: 32})
+	benchmarkRangeScan(b, tree.Insert, func(from, to key, fn func(key) bool) {
+		tree.AscendRange(from, to, fn)
+	})
+}
+
+func BenchmarkBTreeRangeScan(b *testing.B) {
+	tree := NewBTreeWithDegree[key](32)
+	benchmarkRangeScan(b, tree.Insert, func(from, to key, fn func(key) bool) {
+		for k := range tree.Range(from, to-1) {
+			if !fn(k) {
+				return
+			}
+		}
+	})
+}
@@ -7,13 +7,33 @@
 // operations.
 package btree
 
+import (
+	"errors"
+	"iter"
+	"math"
+	"math/bits"
+	"sort"
+)
+
 const (
-	t = 512
+	// defaultDegree is the branching factor t of trees created without one.
+	defaultDegree = 512
+
+	// estimateDepth is the number of levels descended by EstimateCountRange.
+	estimateDepth = 2
 )
 
+// ErrHeightExceeded is returned by TryInsert where an insert would grow a tree
+// beyond its MaxHeight.
+var ErrHeightExceeded = errors.New("btree: insert would exceed the maximum height of the tree")
+
 // Comparable defines a total ordering of values of type T. Values within
 // BTree are constrained by Comparable to to indicate the order in which they
-// are stored.
+// are stored. Values which compare equal are treated as the same key, and are
+// merged by the tree: the most recently inserted value replaces the other.
+// A multiset, created by NewMultiBTree, instead keeps every value inserted.
+// Trees created by NewBTreeFunc hold keys of any type, ordered by a function
+// in place of Compare.
 type Comparable[T any] interface {
 	// Compare is called on a value of type T, with another value of type T and
 	// indicates the relative order of the two values by returning an int.
@@ -23,217 +43,2505 @@ type Comparable[T any] interface {
 	Compare(T) int
 }
 
-type BTree[T Comparable[T]] struct {
-	root rootNode[T]
-}
+type BTree[T any] struct {
+	root    rootNode[T]
+	cfg     *config[T]
+	size    int
+	version uint64
+	recent  *recency[T]
+
+	// OnEqualConflict, if set, is called by Insert whenever the inserted key
+	// compares equal to a stored value, which it then replaces. Where Compare
+	// is only a partial order, reporting incomparable values as equal, this
+	// surfaces values which would otherwise be silently overwritten. The
+	// callback is left to decide whether old and new are observably different.
+	OnEqualConflict func(old, new T)
+
+	// BeforeInsert and BeforeRemove, if set, are called with the key passed to
+	// Insert and Remove, and so by the methods built on them, such as RemoveAt
+	// and RemoveRangeFunc, before the tree is changed in any way. PopMin and
+	// PopMax likewise call BeforeRemove with the key they're about to remove.
+	// Each is called whether or not a value matching the key is already in the
+	// tree, so that mutations written to a log as they're reported can be
+	// replayed into an empty tree in the same order to rebuild it. Should a
+	// hook panic, the tree is left unchanged. Other methods which change the
+	// tree, such as Clear and SplitTopK, don't call either hook.
+	BeforeInsert func(T)
+	BeforeRemove func(T)
+}
+
+// Options configures optional behaviour of a BTree holding keys of type T,
+// fixed when the tree is created.
+type Options[T any] struct {
+	// LinkLeaves threads a doubly linked list through the leaf nodes of the
+	// tree, maintained as nodes are split and merged. ScanLeaves uses it to
+	// walk the tree by hopping between sibling leaves.
+	LinkLeaves bool
+
+	// Sequence records a sequence number with each key as it is inserted,
+	// counting up from zero across every key inserted into the tree, which
+	// AscendWithSeq reports alongside each key. An insert which replaces an
+	// existing value keeps the sequence number of that value. The numbers are
+	// stored beside the keys of every node, costing 8 bytes for each key held
+	// by the tree.
+	Sequence bool
+
+	// RecentAccesses, if positive, has Search record each key it finds, so
+	// that RecentlyAccessed can report up to that many of the distinct keys
+	// most recently found. The keys are recorded under a mutex, against which
+	// concurrent searches contend, and each search which finds a key scans the
+	// recorded keys to move it to the front, costing up to RecentAccesses
+	// comparisons. RecentAccesses keys are held in addition to the tree.
+	RecentAccesses int
+
+	// Degree is the branching factor t of the tree, such that each node other
+	// than the root holds between t-1 and 2t-1 keys. A larger degree makes for
+	// a shallower tree with fewer, larger nodes, suiting small keys which are
+	// cheap to compare, while a smaller degree makes for fewer comparisons in
+	// each node, suiting keys which are expensive to compare. Degree must be
+	// greater than 2, or else zero for the default of 512.
+	Degree int
+
+	// Multiset keeps every key inserted into the tree, even those comparing
+	// equal to keys already held, rather than replacing them. Each key is
+	// placed after those equal to it, or before them once the tree has been
+	// reversed, so equal keys are walked in the order they were inserted, or
+	// newest first once reversed. Search, Remove and the other methods looking
+	// for a key act on the first of the keys matching it in ascending order,
+	// which is the earliest inserted of them, or the latest once reversed.
+	Multiset bool
+
+	// MaxHeight, if positive, caps the number of levels of the tree, bounding
+	// the nodes visited by any search. TryInsert refuses any insert which would
+	// split a full root into a new level beyond it, so the tree holds at most
+	// (2t)^MaxHeight-1 keys, and may refuse inserts with far fewer, depending on
+	// how full its nodes are. Only inserts are refused; trees built or joined
+	// by other methods may grow past it.
+	MaxHeight int
+
+	// PoolNodes keeps the nodes merged away as keys are removed in a pool, from
+	// which nodes are then taken as others are split, rather than allocating
+	// them anew, easing the load on the garbage collector under a steady churn
+	// of inserts and removals. The pool is a sync.Pool, so nodes left unused
+	// are still collected in time.
+	PoolNodes bool
+
+	// Weight, if set, weighs each key of the tree, so that SelectByWeight can
+	// find the key at which the running total of the weights reaches a target
+	// in a single descent, rather than a scan of the keys. Each node records
+	// the total weight of its own keys, and each internal node that of every
+	// key beneath it, kept up to date as keys are inserted, removed and moved
+	// between nodes, at the cost of a call to Weight for each key moved.
+	// Weights must not be negative, and the weight of a key must not change
+	// while it's held by the tree.
+	Weight func(T) int
+
+	// OrderStatistics has each internal node record the number of keys
+	// beneath it, kept up to date as keys are inserted, removed and moved
+	// between nodes, so that the methods finding keys by their position, such
+	// as Rank, Select, RemoveAt, IndexRange and SplitTopK, take a single
+	// descent of O(logₜn) time. Without it, those methods count the keys of
+	// each subtree they pass over as they go, taking O(n) time, while other
+	// methods pay nothing for the counts. A multiset always records them, as
+	// it removes a key chosen from among equal keys by its position.
+	OrderStatistics bool
+}
+
+// config holds the settings of a BTree which are shared by each of its nodes.
+type config[T any] struct {
+	cmp        func(a, b T) int // The order of the keys, that of Compare by default
+	t          int              // The branching factor of the tree
+	linkLeaves bool
+	sequence   bool
+	seq        uint64 // The sequence number of the next key inserted
+	reversed   bool   // Whether the tree is ordered by Compare negated
+	maxHeight  int    // The most levels Insert may grow the tree to, if positive
+	multiset   bool   // Whether keys comparing equal are all kept
+	counted    bool   // Whether internal nodes record the number of keys beneath them
+	pool       *nodePool[T]
+	weight     func(T) int // The weight of each key, if the tree weighs its keys
+}
+
+func NewBTree[T Comparable[T]]() *BTree[T] {
+	return NewBTreeWithOptions(Options[T]{})
+}
+
+// NewMultiBTree creates an empty multiset, a tree which keeps every key
+// inserted into it, including those comparing equal to one another.
+func NewMultiBTree[T Comparable[T]]() *BTree[T] {
+	return NewBTreeWithOptions(Options[T]{Multiset: true})
+}
+
+// NewBTreeWithDegree creates an empty tree with the branching factor t, which
+// must be greater than 2.
+func NewBTreeWithDegree[T Comparable[T]](t int) *BTree[T] {
+	if t <= 2 {
+		panic("btree: degree must be greater than 2")
+	}
+	return NewBTreeWithOptions(Options[T]{Degree: t})
+}
+
+// NewBTreeWithOptions creates an empty tree configured by opts, panicking if
+// opts.Degree is neither zero nor greater than 2.
+func NewBTreeWithOptions[T Comparable[T]](opts Options[T]) *BTree[T] {
+	return newBTree(T.Compare, opts)
+}
+
+// NewBTreeFunc creates an empty tree ordering its keys by cmp rather than by a
+// Compare method, so that types such as int and string, which have none, can
+// be held directly. cmp(a, b) reports the order of a and b as Compare would,
+// and is used in place of Compare throughout the tree.
+func NewBTreeFunc[T any](cmp func(a, b T) int) *BTree[T] {
+	return newBTree(cmp, Options[T]{})
+}
+
+// newBTree creates an empty tree ordered by cmp and configured by opts.
+func newBTree[T any](cmp func(a, b T) int, opts Options[T]) *BTree[T] {
+	cfg := newConfig(cmp, opts)
+	b := &BTree[T]{root: newRootLeafNode(cfg), cfg: cfg}
+	if opts.RecentAccesses > 0 {
+		b.recent = newRecency[T](opts.RecentAccesses)
+	}
+	return b
+}
+
+// FromSortedSlice creates a tree holding keys, which must already be sorted in
+// ascending order with no two keys comparing equal; the tree isn't valid
+// otherwise. Rather than inserting the keys one by one, splitting nodes as
+// they fill, the tree is built bottom up at the least height able to hold the
+// keys, in O(n) time without comparing any of them.
+func FromSortedSlice[T Comparable[T]](keys []T) *BTree[T] {
+	b := NewBTree[T]()
+	b.load(keys)
+	return b
+}
+
+// BuildFromKeys creates a tree from keys, reconstructing each value of the
+// tree from its key with reconstruct, such as where only the keys of a tree
+// were stored and the rest of each value can be recomputed. keys must already
+// be sorted, so that the reconstructed values ascend in the order of Compare
+// with no two equal; the tree is built from them directly in O(n) time without
+// comparing them, and isn't valid otherwise.
+func BuildFromKeys[T Comparable[T], K any](keys []K, reconstruct func(K) T) *BTree[T] {
+	values := make([]T, len(keys))
+	for i, k := range keys {
+		values[i] = reconstruct(k)
+	}
+	b := NewBTree[T]()
+	b.load(values)
+	return b
+}
+
+// newConfig returns the configuration of a tree ordered by cmp and configured
+// by opts, panicking if opts.Degree is too small.
+func newConfig[T any](cmp func(a, b T) int, opts Options[T]) *config[T] {
+	degree := opts.Degree
+	switch {
+	case degree == 0:
+		degree = defaultDegree
+	case degree <= 2:
+		panic("btree: degree must be greater than 2")
+	}
+	cfg := &config[T]{
+		cmp:        cmp,
+		t:          degree,
+		linkLeaves: opts.LinkLeaves,
+		sequence:   opts.Sequence,
+		maxHeight:  opts.MaxHeight,
+		multiset:   opts.Multiset,
+		counted:    opts.OrderStatistics || opts.Multiset,
+		weight:     opts.Weight,
+	}
+	if opts.PoolNodes {
+		cfg.pool = &nodePool[T]{}
+	}
+	return cfg
+}
+
+// Len returns the number of keys in the tree. The count is kept as keys are
+// inserted and removed, so takes constant time.
+func (b BTree[T]) Len() int {
+	return b.size
+}
+
+// IsEmpty reports whether the tree holds no keys, which is so only when its
+// root is a leaf without any, as after Clear or once the last key is removed.
+func (b BTree[T]) IsEmpty() bool {
+	return numKeys[T](b.root) == 0
+}
+
+// Height returns the number of levels of the tree, from the root down to the
+// leaves, which all lie at the same depth: zero if the tree is empty, and one
+// if the root is a leaf holding keys.
+func (b BTree[T]) Height() int {
+	if b.size == 0 {
+		return 0
+	}
+	return height[T](b.root) + 1
+}
+
+// Version returns the version of the tree, which starts at zero and increases
+// with each change made to the keys of the tree, or to their order. A reader
+// can record the version it observed, and later tell whether the tree has
+// changed since by comparing versions, without comparing any keys. Versions
+// belong to each tree, so the versions of different trees, including those of
+// copies of a tree, can't be compared, and the version of a tree returns to
+// zero when it's cleared.
+func (b BTree[T]) Version() uint64 {
+	return b.version
+}
+
+// Clear removes every key from the tree, returning its version to zero.
+func (b *BTree[T]) Clear() {
+	b.root, b.size, b.version = newRootLeafNode(b.cfg), 0, 0
+}
+
+// Compare compares a and c in the order used by the tree, so that code working
+// alongside the tree, such as merging its keys with others, can agree with it.
+// Once the tree has been reversed, this is the opposite of a.Compare(c).
+func (b BTree[T]) Compare(a, c T) int {
+	return b.cfg.compare(a, c)
+}
+
+// compare compares a and b in the order of the tree, which is that of Compare
+// unless the tree has been reversed.
+func (c *config[T]) compare(a, b T) int {
+	if c.reversed {
+		return c.cmp(b, a)
+	}
+	return c.cmp(a, b)
+}
+
+// lower compares a and b in the order of the tree, except that in a multiset, a
+// is taken to be less than any key equal to it, so that find reports the
+// position of the first of a run of keys equal to a.
+func (c *config[T]) lower(a, b T) int {
+	compared := c.compare(a, b)
+	if compared == 0 && c.multiset {
+		return -1
+	}
+	return compared
+}
+
+// upper compares a and b in the order of the tree, except that in a multiset, a
+// is taken to be greater than any key equal to it, so that find reports the
+// position following the last of a run of keys equal to a. Keys are inserted
+// there, so that a key inserted into a multiset is never found to match one
+// already held.
+func (c *config[T]) upper(a, b T) int {
+	compared := c.compare(a, b)
+	if compared == 0 && c.multiset {
+		return 1
+	}
+	return compared
+}
+
+// placed compares a, a key being inserted, with b for the position at which to
+// insert it, as upper does, except that in a reversed multiset a is taken to be
+// less than any key equal to it. Keys comparing equal to one another are so
+// walked in the order they were inserted, or newest first once the tree has
+// been reversed, just as a run of equal keys reverses along with the tree.
+func (c *config[T]) placed(a, b T) int {
+	if c.reversed {
+		return c.lower(a, b)
+	}
+	return c.upper(a, b)
+}
+
+// before compares a and b in the order of the tree, except that a is taken to be
+// less than any key equal to it, so that find reports the position of the first
+// key not less than a.
+func (c *config[T]) before(a, b T) int {
+	if compared := c.compare(a, b); compared != 0 {
+		return compared
+	}
+	return -1
+}
+
+// after compares a and b like before, except that a is taken to be greater than
+// any key equal to it, so that find reports the position of the first key
+// greater than a.
+func (c *config[T]) after(a, b T) int {
+	if compared := c.compare(a, b); compared != 0 {
+		return compared
+	}
+	return 1
+}
+
+// probe finds k among keys, those of a node with the given children, for a
+// search for the value matching k, returning the index of the match if there's
+// one, or else of the child in which it's to be sought. A multiset may hold
+// several keys matching k, ordered by when they were inserted, and the search
+// is then for the first of them in the order of the tree, so that the ties are
+// always broken alike. A match among keys is so reported only if no key in the
+// child before it matches k too, which is so when the last key of that child
+// doesn't.
+func (c *config[T]) probe(keys list[T], children list[childNode[T]], k T) (int, bool) {
+	if !c.multiset {
+		return find(keys, k, c.compare)
+	}
+	i, _ := find(keys, k, c.lower)
+	found := i < len(keys) && c.compare(k, keys[i]) == 0
+	if found && children != nil && c.compare(children[i].max(), k) == 0 {
+		found = false
+	}
+	return i, found
+}
+
+// Search searches the tree recursively for the value matching key if such a
+// value exists. If the tree was created with RecentAccesses, the value found is
+// recorded as the most recently accessed.
+func (b BTree[T]) Search(key T) (T, bool) {
+	v, found := b.root.search(key)
+	if found && b.recent != nil {
+		b.recent.record(v, b.cfg.compare)
+	}
+	return v, found
+}
+
+// Count returns the number of keys in the tree matching key, which is only
+// ever more than one in a multiset. There, the keys either side of the run of
+// keys matching key are found in two descents, taking O(logₜn) time, while in
+// any other tree Count is a Search, reporting 0 or 1.
+func (b BTree[T]) Count(key T) int {
+	if !b.cfg.multiset {
+		if _, found := b.root.search(key); found {
+			return 1
+		}
+		return 0
+	}
+	_, _, count := b.EqualRange(key, b.cfg.compare)
+	return count
+}
+
+// Contains reports whether the tree holds a value matching key, in the same
+// way as Search.
+func (b BTree[T]) Contains(key T) bool {
+	_, found := b.Search(key)
+	return found
+}
+
+// SearchAll searches the tree for the value matching each of keys like Search,
+// returning the values found in the same order as keys, along with whether
+// each was found. Where no value matches a key, its value is the zero value of
+// T. If keys are sorted in ascending order, they're matched in a single walk
+// of the tree alongside them, taking O(n+m) time for m keys rather than
+// searching for each in turn.
+func (b BTree[T]) SearchAll(keys []T) ([]T, []bool) {
+	var (
+		values = make([]T, len(keys))
+		found  = make([]bool, len(keys))
+	)
+	if !sort.SliceIsSorted(keys, func(i, j int) bool { return b.cfg.compare(keys[i], keys[j]) < 0 }) {
+		for i, k := range keys {
+			values[i], found[i] = b.Search(k)
+		}
+		return values, found
+	}
+	var (
+		c     = newCursor[T](b.root)
+		k, ok = c.next()
+	)
+	for i, key := range keys {
+		for ok && b.cfg.compare(k, key) < 0 {
+			k, ok = c.next()
+		}
+		if ok && b.cfg.compare(k, key) == 0 {
+			values[i], found[i] = k, true
+			if b.recent != nil {
+				b.recent.record(k, b.cfg.compare)
+			}
+		}
+	}
+	return values, found
+}
+
+// RecentlyAccessed returns up to n of the distinct keys most recently found by
+// Search, most recent first, if the tree was created with RecentAccesses, and
+// nil otherwise. Keys are reported as they were found, even if they've since
+// been removed from the tree.
+func (b BTree[T]) RecentlyAccessed(n int) []T {
+	if b.recent == nil {
+		return nil
+	}
+	return b.recent.recent(n)
+}
+
+// AreAdjacent reports whether a and c are both in the tree, with c following
+// immediately after a in ascending order, so that no key lies between them.
+// Both are found in a single search for a, which finds the key after it too.
+func (b BTree[T]) AreAdjacent(a, c T) bool {
+	var nb neighbours[T]
+	_, found := b.root.searchNeighbours(a, &nb)
+	return found && nb.hasNext && b.cfg.compare(nb.next, c) == 0
+}
+
+// SearchBounded searches the tree for the value matching key like Search, but
+// visits at most maxDepth levels of the tree, counting the root as the first.
+// conclusive is false if the search was cut off before reaching a leaf without
+// finding key, in which case key may or may not be in the tree. Where maxDepth
+// is at least the height of the tree, SearchBounded behaves exactly like Search
+// and is always conclusive. In a multiset, the value found may be any of those
+// matching key, as telling whether it's the first of them may take a look at
+// the levels below.
+func (b BTree[T]) SearchBounded(key T, maxDepth int) (value T, found, conclusive bool) {
+	var n node[T] = b.root
+	for depth := 0; depth < maxDepth; depth++ {
+		keys, children := n.contents()
+		i, ok := find(keys, key, b.cfg.compare)
+		if ok {
+			return keys[i], true, true
+		}
+		if children == nil {
+			return value, false, true
+		}
+		n = children[i]
+	}
+	return value, false, false
+}
+
+// Floor returns the largest key in the tree less than or equal to key, or false
+// if every key is greater than key. The key of each node along the descent
+// which precedes the position of key becomes the best candidate so far.
+func (b BTree[T]) Floor(key T) (floor T, found bool) {
+	var n node[T] = b.root
+	for {
+		keys, children := n.contents()
+		i, ok := b.cfg.probe(keys, children, key)
+		if ok {
+			return keys[i], true
+		}
+		if i > 0 {
+			floor, found = keys[i-1], true
+		}
+		if children == nil {
+			return
+		}
+		n = children[i]
+	}
+}
+
+// Ceiling returns the smallest key in the tree greater than or equal to key, or
+// false if every key is less than key, in the same way as Floor.
+func (b BTree[T]) Ceiling(key T) (ceiling T, found bool) {
+	var n node[T] = b.root
+	for {
+		keys, children := n.contents()
+		i, ok := b.cfg.probe(keys, children, key)
+		if ok {
+			return keys[i], true
+		}
+		if i < len(keys) {
+			ceiling, found = keys[i], true
+		}
+		if children == nil {
+			return
+		}
+		n = children[i]
+	}
+}
+
+// Predecessor returns the largest key in the tree strictly less than key, or
+// false if no key is less than key. Unlike Floor, the descent carries on past
+// any key equal to key down to a leaf, and the key of each node along it which
+// precedes the position of key becomes the best candidate so far.
+func (b BTree[T]) Predecessor(key T) (prev T, found bool) {
+	var n node[T] = b.root
+	for {
+		keys, children := n.contents()
+		i, _ := find(keys, key, b.cfg.before)
+		if i > 0 {
+			prev, found = keys[i-1], true
+		}
+		if children == nil {
+			return
+		}
+		n = children[i]
+	}
+}
+
+// Successor returns the smallest key in the tree strictly greater than key, or
+// false if no key is greater than key, in the same way as Predecessor.
+func (b BTree[T]) Successor(key T) (next T, found bool) {
+	var n node[T] = b.root
+	for {
+		keys, children := n.contents()
+		i, _ := find(keys, key, b.cfg.after)
+		if i < len(keys) {
+			next, found = keys[i], true
+		}
+		if children == nil {
+			return
+		}
+		n = children[i]
+	}
+}
+
+// SearchContext searches the tree for the value matching key like Search,
+// collecting the keys either side of it in the same descent. prev is the key
+// before the match in ascending order and next the key after it; if no value
+// matches key these are the largest key less than key and the smallest key
+// greater than it. In a multiset, next may match key as well, as the match is
+// the first of the keys matching key, while prev never does. Either of prev and
+// next is left as the zero value of T when no such key exists in the tree.
+func (b BTree[T]) SearchContext(key T) (prev, match, next T, found bool) {
+	var nb neighbours[T]
+	match, found = b.root.searchNeighbours(key, &nb)
+	return nb.prev, match, nb.next, found
+}
+
+// Insert inserts key into the tree or updates an existing value matching key
+// if such a value exists, calling OnEqualConflict if set in the latter case.
+// A multiset never updates a value, instead adding key after those matching it.
+// Insert panics with ErrHeightExceeded where TryInsert would return it.
+func (b *BTree[T]) Insert(key T) {
+	if err := b.TryInsert(key); err != nil {
+		panic(err)
+	}
+}
+
+// InsertAll inserts each of keys into the tree with Insert, leaving the tree
+// holding the same keys as inserting them one by one in the order given. The
+// keys are first sorted, in a copy which leaves keys as it is, so that each
+// insertion descends through the nodes just visited by the one before it, and
+// BeforeInsert, OnEqualConflict and sequence numbers follow the sorted order.
+// Keys of the batch which compare equal aren't collapsed beforehand; rather,
+// the sort keeps them in the order given, so that each replaces the one before
+// it as with Insert, and only the last remains. In a multiset, all are added.
+func (b *BTree[T]) InsertAll(keys ...T) {
+	sorted := append([]T(nil), keys...)
+	sort.SliceStable(sorted, func(i, j int) bool { return b.cfg.compare(sorted[i], sorted[j]) < 0 })
+	for _, k := range sorted {
+		b.Insert(k)
+	}
+}
+
+// TryInsert inserts key into the tree like Insert, unless the root of the tree
+// is full and the tree already has MaxHeight levels, in which case it returns
+// ErrHeightExceeded and leaves the tree unchanged, without calling BeforeInsert.
+// Since the root must be split before any key can be inserted beneath it, this
+// applies even where a value matching key is already in the tree.
+func (b *BTree[T]) TryInsert(key T) error {
+	_, _, err := b.insert(key, true, nil)
+	return err
+}
+
+// GetOrInsert returns the value in the tree matching key if there is one, and
+// otherwise inserts key and returns it, reporting whether a matching value was
+// already present. The value found is left in place rather than replaced, so
+// OnEqualConflict isn't called, but BeforeInsert is called with key either way,
+// as by Insert. Both cases take the single descent of an insertion, which stops
+// at the value matching key if it meets one. A multiset always inserts key.
+// GetOrInsert panics with ErrHeightExceeded where TryInsert would return it.
+func (b *BTree[T]) GetOrInsert(key T) (T, bool) {
+	old, found, err := b.insert(key, false, nil)
+	if err != nil {
+		panic(err)
+	}
+	if found {
+		return old, true
+	}
+	return key, false
+}
+
+// ReplaceOrInsert inserts key into the tree as Insert does, returning the value
+// matching key which it replaced, or false if there was none. This is so where
+// keys carry more than Compare looks at, and the value displaced is wanted. A
+// multiset never replaces a value, and so never returns one. ReplaceOrInsert
+// panics with ErrHeightExceeded where TryInsert would return it.
+func (b *BTree[T]) ReplaceOrInsert(key T) (old T, existed bool) {
+	old, existed, err := b.insert(key, true, nil)
+	if err != nil {
+		panic(err)
+	}
+	return old, existed
+}
+
+// insert inserts key into the tree as TryInsert does, returning the value
+// matching key already in the tree, if any, which is replaced by key only if
+// replace is true.
+func (b *BTree[T]) insert(key T, replace bool, hint *Cursor[T]) (old T, found bool, err error) {
+	full := !b.root.isBelowMax()
+	if full && b.atMaxHeight() {
+		return old, false, ErrHeightExceeded
+	}
+	if b.BeforeInsert != nil {
+		b.BeforeInsert(key)
+	}
+	b.root = b.root.mutable(b.cfg)
+	if full {
+		b.grow()
+	}
+	old, found = b.root.insertBelowMax(item[T]{key, b.cfg.seq}, replace, hint)
+	if !found {
+		b.version++
+		b.size++
+		b.cfg.seq++
+		return old, false, nil
+	}
+	if replace {
+		b.version++
+		if b.OnEqualConflict != nil {
+			b.OnEqualConflict(old, key)
+		}
+	}
+	return old, true, nil
+}
+
+// atMaxHeight reports whether a new level above the root would take the tree
+// beyond its MaxHeight, if it has one.
+func (b BTree[T]) atMaxHeight() bool {
+	return b.cfg.maxHeight > 0 && height[T](b.root)+1 >= b.cfg.maxHeight
+}
+
+// grow splits the full root of the tree about its median key, which becomes
+// the only key of a new root above it.
+func (b *BTree[T]) grow() {
+	var (
+		root    = b.root.asChild()
+		newRoot = newRootInternalNode(b.cfg)
+	)
+
+	// New values are always placed inside a leaf node. The insert operation
+	// recurses down tree in a single pass, searching for the appropriate
+	// position in the appropriate leaf node in which to place the value.
+	// To guarantee that there is always enough space to place new values;
+	// that recursion never descends into a full node; such nodes are split
+	// about their median key as they are encountered.
+	//
+	// Here, the root node of a B-Tree with t = 4 becomes the first child of
+	// a new node created from the the median key H, increasing the height
+	// of the tree by 1:
+	//
+	// root
+	// (A D F  H L N P)
+	// ↓ ↓ ↓ ↓  ↓ ↓ ↓ ↓
+	// T₁T₂T₃T₄ T₁T₂T₃T₄
+	//
+	// The root node originally has and 7 keys (2t-1) and 8 (2t) children.
+	// Splitting results in the creation of a new node node which becomes
+	// the immediate right sibling of what is now the former root. The two
+	// siblings each now have 3 (t-1) keys and 4 (t) children:
+	//
+	//       newRoot
+	//       (H)
+	//       ↓ ↓
+	// root     sibling
+	// (A D F)  (L N P)
+	// ↓ ↓ ↓ ↓  ↓ ↓ ↓ ↓
+	// T₁T₂T₃T₄ T₁T₂T₃T₄
+	median, sibling := root.split()
+	newRoot.insertAt(0, median)
+	newRoot.children.insert(0, root)
+	newRoot.children.insert(1, sibling)
+	newRoot.recount()
+	b.root = newRoot
+}
+
+// UpdateValue replaces the value matching key with the result of calling update
+// with it, returning false if no value matches key. update must return a value
+// comparing equal to key, such as one with a new payload under the same key,
+// so that the tree stays in order; UpdateValue panics otherwise, leaving the
+// stored value as it was.
+func (b *BTree[T]) UpdateValue(key T, update func(old T) T) bool {
+	// The path to key is made to belong to the tree as it's descended, in case
+	// key is found at the end of it.
+	// The internal nodes on the path are kept so that their total weights can
+	// take in any change in the weight of the value.
+	b.root = b.root.mutable(b.cfg)
+	var (
+		n    node[T] = b.root
+		path []*baseInternalNode[T]
+	)
+	for {
+		keys, children := n.contents()
+		if children != nil {
+			path = append(path, internalOf(n))
+		}
+		i, found := b.cfg.probe(keys, children, key)
+		if found {
+			old := keys[i]
+			v := update(old)
+			if b.cfg.compare(v, old) != 0 {
+				panic("btree: UpdateValue changed the order of a key")
+			}
+			keysOf(n).put(i, v)
+			for _, p := range path {
+				p.replaced(old, v)
+			}
+			b.version++
+			return true
+		}
+		if children == nil {
+			return false
+		}
+		n = children[i].mutable(b.cfg)
+		children[i] = n.(childNode[T])
+	}
+}
+
+// Remove removes the value matching key from the the tree if such a value
+// exists, and may result in the shrinking of the tree. Only one such value is
+// removed from a multiset.
+func (b *BTree[T]) Remove(key T) {
+	if b.BeforeRemove != nil {
+		b.BeforeRemove(key)
+	}
+	b.remove(&seek[T]{key: key})
+}
+
+// remove removes the key sought by s from the tree, returning it, or false if
+// there's no such key.
+func (b *BTree[T]) remove(s *seek[T]) (old T, removed bool) {
+	// Like with insertion, removal recurses down the tree in a single pass,
+	// rearranging the tree as it goes to maintain its invariants. Unlike
+	// insertion, keys can be removed from leaf nodes or internal nodes. Now,
+	// care must be taken to ensure that recursion doesn't descend into a node
+	// that is too small, rather than one that is too big. This is done by
+	// shuffling spare keys between siblings, or merging siblings if necessary.
+	b.root = b.root.mutable(b.cfg)
+	if old, removed = b.root.remove(s); removed {
+		b.size--
+		b.version++
+	}
+	if !b.root.isAboveMin() {
+
+		// Further, in contrast to the case of insertion into a B-Tree rooted at
+		// a full node, where the height of tree increases by 1; removal from a
+		// B-Tree may result in an empty internal root node, in which case the
+		// first child of the root becomes the new root of the tree.
+		//
+		// Here, D is removed from a tree with t = 3. The first child of the
+		// root becomes the new root of the tree, decreasing the height of the
+		// tree by 1.
+		//
+		//                     root
+		//                     (P)
+		//                    ↓  ↓
+		//     first child             second child
+		//     (C        L)            (T     X)
+		//     ↓     ↓    ↓            ↓   ↓   ↓
+		// (A B) (D E J K) (N 0) (Q R S) (U V) (Y Z)
+		//
+		// Both children of the root node each with 2 (t-1) keys and 3 (t)
+		// children, are too small for recursion to continue. The siblings are
+		// merged about P, which moves down from the root to become the median
+		// key of the merged node with 5 (2t -1) keys and 6 (t) children, which
+		// becomes new root of the tree.
+		//
+		//     new root
+		//     (C       L     P       T     X)
+		//     ↓    ↓      ↓      ↓      ↓   ↓
+		// (A B) (E J K) (N 0) (Q R S) (U V) (Y Z)
+		b.root = b.root.shrink()
+	}
+	return old, removed
+}
+
+// seek directs a removal to the key it removes, either the key matching key
+// or, if byRank is set, the key at the position rank in order among the keys
+// of the subtree being descended.
+type seek[T any] struct {
+	key    T
+	rank   int
+	byRank bool
+}
+
+// in finds the key sought by s among keys, those of a node with the given
+// children, returning its index if found there, or else the index of the
+// child leading to it. A seek by rank moves on to the rank of the key within
+// that child.
+func (s *seek[T]) in(cfg *config[T], keys list[T], children list[childNode[T]]) (int, bool) {
+	if !s.byRank {
+		return cfg.probe(keys, children, s.key)
+	}
+	if children == nil {
+		return s.rank, true
+	}
+	for i := range keys {
+		size := children[i].size()
+		switch {
+		case s.rank < size:
+			return i, false
+		case s.rank == size:
+			return i, true
+		}
+		s.rank -= size + 1
+	}
+	return len(keys), false
+}
+
+// PopMin removes the smallest key from the tree, returning it, or false if the
+// tree is empty.
+func (b *BTree[T]) PopMin() (T, bool) {
+	return b.pop(true)
+}
+
+// PopMax removes the largest key from the tree, returning it, or false if the
+// tree is empty.
+func (b *BTree[T]) PopMax() (T, bool) {
+	return b.pop(false)
+}
+
+// pop removes the first key of the tree if first is set, or else the last, in
+// a single descent along the leftmost or rightmost spine of the tree by
+// deleteSucc or deletePred. The root is taken as a child to do so, and shrunk
+// afterwards as by Remove.
+func (b *BTree[T]) pop(first bool) (T, bool) {
+	if b.size == 0 {
+		var zero T
+		return zero, false
+	}
+	if b.BeforeRemove != nil {
+		if first {
+			b.BeforeRemove(b.root.min())
+		} else {
+			b.BeforeRemove(b.root.max())
+		}
+	}
+	var (
+		root = b.root.mutable(b.cfg).asChild()
+		it   item[T]
+	)
+	if first {
+		it = root.deleteSucc()
+	} else {
+		it = root.deletePred()
+	}
+	b.root = root.asRoot()
+	b.size--
+	b.version++
+	if !b.root.isAboveMin() {
+		b.root = b.root.shrink()
+	}
+	return it.key, true
+}
+
+// PreSplit shapes the tree so that each of boundaries becomes a key of an
+// internal node, separating the keys either side of it into distinct subtrees,
+// so that writes to different ranges go to different nodes. Any boundary which
+// isn't in the tree is inserted, as a placeholder if need be. PreSplit is only
+// a hint: a boundary is moved up out of its leaf only if the leaf holds enough
+// keys either side of it to be split about it, and the keys inserted or
+// removed afterwards may move it again. In a tree with a MaxHeight, the root is
+// never split into a new level beyond it, leaving any boundary which would need
+// one where it is, and PreSplit stops at the first boundary which TryInsert
+// refuses, returning ErrHeightExceeded with the boundaries before it in place.
+func (b *BTree[T]) PreSplit(boundaries []T) error {
+	for _, k := range boundaries {
+		if _, found := b.root.search(k); !found {
+			if err := b.TryInsert(k); err != nil {
+				return err
+			}
+		}
+		tall := b.atMaxHeight()
+		b.root = b.root.mutable(b.cfg)
+		if !b.root.isBelowMax() {
+			if tall {
+				continue
+			}
+			b.grow()
+		}
+		switch root := b.root.(type) {
+		case *rootInternalNode[T]:
+			root.separate(k)
+		case *rootLeafNode[T]:
+			i, _ := find(root.keys, k, b.cfg.compare)
+			if tall || i < b.cfg.t-1 || len(root.keys)-i-1 < b.cfg.t-1 {
+				continue
+			}
+			var (
+				leaf            = root.asChild().(*childLeafNode[T])
+				newRoot         = newRootInternalNode(b.cfg)
+				median, sibling = leaf.splitAround(i)
+			)
+			newRoot.insertAt(0, median)
+			newRoot.children.insert(0, leaf)
+			newRoot.children.insert(1, sibling)
+			newRoot.recount()
+			b.root = newRoot
+		}
+	}
+	return nil
+}
+
+// Min returns the smallest key in the tree if the tree isn't empty.
+func (b BTree[T]) Min() (key T, found bool) {
+	if b.root.isAboveMin() {
+		return b.root.min(), true
+	}
+	return
+}
+
+// Max returns the largest key in the tree if the tree isn't empty.
+func (b BTree[T]) Max() (key T, found bool) {
+	if b.root.isAboveMin() {
+		return b.root.max(), true
+	}
+	return
+}
+
+// Bounds returns both the smallest and the largest keys in the tree, in a
+// single pass down the leftmost and then the rightmost spine of the tree. ok
+// is false if the tree is empty.
+func (b BTree[T]) Bounds() (min, max T, ok bool) {
+	if !b.root.isAboveMin() {
+		return
+	}
+	return b.root.min(), b.root.max(), true
+}
+
+// Ascend calls fn for each key in the tree in ascending order, until fn returns
+// false. Outside of a multiset no two keys in the tree compare equal, so the
+// order of the walk is fully determined by Compare: trees holding the same keys
+// are walked in the same order, regardless of the sequence of operations which
+// built them. In a multiset, keys comparing equal are walked in the order they
+// were inserted, or newest first once the tree has been reversed, so trees
+// built by the same sequence of operations are walked in the same order.
+func (b BTree[T]) Ascend(fn func(T) bool) {
+	b.root.ascend(fn)
+}
+
+// Walk calls fn for each key in the tree in ascending order, until fn returns
+// false, in the same in-order walk as Ascend and All, for callers who would
+// rather pass a callback than range over an iterator. fn must not modify the
+// tree; what the walk visits once the tree has been changed is undefined.
+func (b BTree[T]) Walk(fn func(key T) bool) {
+	b.root.ascend(fn)
+}
+
+// ToSlice returns the keys of the tree in ascending order. The slice is never
+// nil, even if the tree is empty.
+func (b BTree[T]) ToSlice() []T {
+	keys := make([]T, 0, b.size)
+	b.root.ascend(func(k T) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return keys
+}
+
+// All returns an iterator over the keys of the tree in ascending order, for use
+// with range. Breaking out of the loop stops the walk of the tree.
+func (b BTree[T]) All() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		b.root.ascend(yield)
+	}
+}
+
+// Enumerate returns an iterator over the keys of the tree in ascending order,
+// for use with range, yielding each key along with its position in that order,
+// counting from zero, as Select and Rank number the keys. The positions are
+// counted as the keys are walked, and breaking out of the loop stops the walk.
+func (b BTree[T]) Enumerate() iter.Seq2[int, T] {
+	return func(yield func(int, T) bool) {
+		i := 0
+		b.root.ascend(func(k T) bool {
+			i++
+			return yield(i-1, k)
+		})
+	}
+}
+
+// Backward returns an iterator over the keys of the tree in descending order,
+// for use with range. Breaking out of the loop stops the walk of the tree, so
+// taking the first few of the largest keys visits little more than them.
+func (b BTree[T]) Backward() iter.Seq[T] {
+	return func(yield func(T) bool) {
+		b.root.descend(yield)
+	}
+}
+
+// FirstN returns the n smallest keys in the tree in ascending order, or every
+// key if the tree holds fewer than n. The walk of the tree stops once n keys
+// have been collected, so only they are visited. The slice is never nil, and
+// is empty if n ≤ 0.
+func (b BTree[T]) FirstN(n int) []T {
+	return b.takeN(n, b.root.ascend)
+}
+
+// LastN returns the n largest keys in the tree, also in ascending order, in the
+// same way as FirstN. The keys are collected by walking the tree backwards
+// from its largest key.
+func (b BTree[T]) LastN(n int) []T {
+	keys := b.takeN(n, b.root.descend)
+	list[T](keys).reverse()
+	return keys
+}
+
+// takeN collects up to n keys from walk, stopping it once it has them.
+func (b BTree[T]) takeN(n int, walk func(func(T) bool) bool) []T {
+	if n <= 0 {
+		return []T{}
+	}
+	keys := make([]T, 0, min(n, b.size))
+	walk(func(k T) bool {
+		keys = append(keys, k)
+		return len(keys) < n
+	})
+	return keys
+}
+
+// Range returns an iterator over the keys k of the tree in the range
+// lo ≤ k ≤ hi in ascending order, for use with range. The walk starts from lo,
+// skipping the subtrees before it, and stops at the first key beyond hi, so
+// only the nodes overlapping the range are visited. Nothing is yielded if lo is
+// greater than hi.
+func (b BTree[T]) Range(lo, hi T) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		b.root.ascendFrom(lo, func(k T) bool {
+			return b.cfg.compare(k, hi) <= 0 && yield(k)
+		})
+	}
+}
+
+// AscendPairwise calls fn for each key in the tree in ascending order along
+// with the key following it, until fn returns false. hasNext is false for the
+// last key, with next left as the zero value of T. Each key is held back by
+// one step of the walk until its successor is known.
+func (b BTree[T]) AscendPairwise(fn func(cur T, next T, hasNext bool) bool) {
+	var (
+		cur     T
+		started bool
+	)
+	if !b.root.ascend(func(k T) bool {
+		if started && !fn(cur, k, true) {
+			return false
+		}
+		cur, started = k, true
+		return true
+	}) {
+		return
+	}
+	if started {
+		var zero T
+		fn(cur, zero, false)
+	}
+}
+
+// Gaps calls fn with the gap between each pair of consecutive keys in the tree
+// in ascending order, as measured by gap, until fn returns false. fn is called
+// once fewer times than there are keys in the tree.
+func (b BTree[T]) Gaps(gap func(a, b T) int, fn func(g int) bool) {
+	var (
+		prev    T
+		started bool
+	)
+	b.root.ascend(func(k T) bool {
+		if started && !fn(gap(prev, k)) {
+			return false
+		}
+		prev, started = k, true
+		return true
+	})
+}
+
+// AscendWithSeq calls fn for each key in the tree in ascending order, along
+// with the sequence number it was inserted with, until fn returns false. Unless
+// the tree was created with Sequence, every sequence number is zero.
+func (b BTree[T]) AscendWithSeq(fn func(seq uint64, v T) bool) {
+	b.root.ascendItems(func(it item[T]) bool {
+		return fn(it.seq, it.key)
+	})
+}
+
+// AscendConcat calls fn for each key of trees in turn, walking each tree in
+// ascending order before moving on to the next, until fn returns false. The
+// keys are visited in ascending order overall provided the trees hold disjoint
+// ranges of keys, given in order, such as the partitions of a larger set of
+// keys. Unlike a merge, the keys of different trees aren't compared, so the
+// precondition isn't verified.
+func AscendConcat[T any](trees []*BTree[T], fn func(T) bool) {
+	for _, b := range trees {
+		if !b.root.ascend(fn) {
+			return
+		}
+	}
+}
+
+// AscendGroups walks the tree b in ascending order, calling fn with each run of
+// consecutive keys which key maps to the same bucket, until fn returns false.
+// The order of buckets is assumed to follow the order of the tree, so that the
+// members of each bucket are contiguous. Otherwise, a bucket is delivered once
+// for each separate run of its members.
+func AscendGroups[T any, K comparable](b *BTree[T], key func(T) K, fn func(bucket K, members []T) bool) {
+	var (
+		bucket  K
+		members []T
+	)
+	if !b.root.ascend(func(k T) bool {
+		kb := key(k)
+		if len(members) > 0 && kb != bucket {
+			if !fn(bucket, members) {
+				return false
+			}
+			members = nil
+		}
+		bucket = kb
+		members = append(members, k)
+		return true
+	}) {
+		return
+	}
+	if len(members) > 0 {
+		fn(bucket, members)
+	}
+}
+
+// ScanLeaves calls fn for each key in the tree in ascending order, until fn
+// returns false, in the same way as Ascend. When the tree was created with
+// LinkLeaves the walk hops between sibling leaves instead of recursing through
+// the tree, taking the key separating each pair of leaves from a cursor which
+// walks the internal nodes alone, so that no node is visited twice. Otherwise,
+// ScanLeaves walks the tree with a cursor, keeping the path from the root in
+// place of the call stack. Either way the walk takes O(n) time.
+func (b BTree[T]) ScanLeaves(fn func(T) bool) {
+	leaf := b.root.firstLeaf()
+	if !b.cfg.linkLeaves || leaf == nil {
+		c := newCursor[T](b.root)
+		for k, ok := c.next(); ok; k, ok = c.next() {
+			if !fn(k) {
+				return
+			}
+		}
+		return
+	}
+	separators := &cursor[T]{internal: true}
+	separators.descend(b.root)
+	for ; leaf != nil; leaf = leaf.next {
+		if !leaf.ascend(fn) {
+			return
+		}
+		if k, ok := separators.next(); ok && !fn(k) {
+			return
+		}
+	}
+}
+
+// Clone returns a copy of the tree in O(1) time, which shares the nodes of the
+// tree until either tree changes them. Nodes belong to the tree whose
+// configuration they hold, and any node shared by the two trees belongs to
+// neither: before changing a node, a tree replaces it with a copy of its own,
+// along with each shared node on the path down to it. Each change to either
+// tree so copies at most the nodes it visits, and the two trees are otherwise
+// entirely independent. Clone therefore changes b itself, and mustn't be called
+// while b is being read elsewhere, such as within View of a ConcurrentBTree,
+// whose own Clone takes its write lock instead. The clone begins at version
+// zero, with none of the tree's hooks and, if the tree records recent
+// accesses, none recorded. Clone panics if the tree links its leaves, since
+// the chain of leaves can't be shared between two trees; Copy may be used
+// instead.
+func (b *BTree[T]) Clone() *BTree[T] {
+	if b.cfg.linkLeaves {
+		panic("btree: Clone of a tree with linked leaves")
+	}
+	var (
+		cfg   = *b.cfg
+		other = *b.cfg
+		clone = &BTree[T]{root: b.root, cfg: &other, size: b.size}
+	)
+	b.cfg = &cfg
+	if b.recent != nil {
+		clone.recent = newRecency[T](b.recent.capacity)
+	}
+	return clone
+}
+
+// Copy returns a copy of the tree which shares nothing with it, copying every
+// node in O(n) time, so that either tree may then be changed freely without
+// the cost of copying nodes on write which Clone defers. As for Clone, the copy
+// begins at version zero without the tree's hooks or recent accesses.
+func (b BTree[T]) Copy() *BTree[T] {
+	var (
+		cfg = *b.cfg
+		c   = copier[T]{cfg: &cfg}
+		dup = &BTree[T]{cfg: &cfg, size: b.size}
+	)
+	switch root := b.root.(type) {
+	case *rootLeafNode[T]:
+		dup.root = &rootLeafNode[T]{root.clone(&cfg)}
+	case *rootInternalNode[T]:
+		dup.root = &rootInternalNode[T]{c.copyInternal(root.baseInternalNode)}
+	}
+	if b.recent != nil {
+		dup.recent = newRecency[T](b.recent.capacity)
+	}
+	return dup
+}
+
+// copier copies subtrees into a tree configured by cfg, keeping track of the
+// last leaf it copied so that leaves can be linked as they're copied.
+type copier[T any] struct {
+	cfg  *config[T]
+	last *childLeafNode[T]
+}
+
+// copyInternal returns a copy of the internal node n along with the subtrees
+// rooted at its children.
+func (c *copier[T]) copyInternal(n baseInternalNode[T]) baseInternalNode[T] {
+	dup := n.clone(c.cfg)
+	for i, child := range dup.children {
+		dup.children[i] = c.copy(child)
+	}
+	return dup
+}
+
+// copy returns a copy of the subtree rooted at n.
+func (c *copier[T]) copy(n childNode[T]) childNode[T] {
+	switch n := n.(type) {
+	case *childInternalNode[T]:
+		return &childInternalNode[T]{c.copyInternal(n.baseInternalNode)}
+	case *childLeafNode[T]:
+		leaf := &childLeafNode[T]{baseLeafNode: n.clone(c.cfg)}
+		if c.cfg.linkLeaves {
+			leaf.prev = c.last
+			if c.last != nil {
+				c.last.next = leaf
+			}
+			c.last = leaf
+		}
+		return leaf
+	}
+	panic("unreachable")
+}
+
+// Union returns a new tree configured like b holding every key held by either
+// b or other, where both trees are ordered alike. Of keys comparing equal in
+// both trees, only the value held by b is kept. The trees are walked in step,
+// and the new tree built directly from the merged keys, taking O(n+m) time
+// without changing either tree.
+func (b BTree[T]) Union(other *BTree[T]) *BTree[T] {
+	keys := make([]T, 0, b.size+other.size)
+	walkInStep(&b, other, func(ka, kb T, inA, _ bool) bool {
+		if !inA {
+			ka = kb
+		}
+		keys = append(keys, ka)
+		return true
+	})
+	u := b.emptyLike()
+	u.load(keys)
+	return u
+}
+
+// Intersection returns a new tree configured like b holding the keys held by
+// both b and other, where both trees are ordered alike, keeping the values
+// held by b. The trees are walked in step, taking O(n+m) time, unless one tree
+// is so much smaller than the other that searching the larger tree for each
+// key of the smaller, in O(m·logₜn) time, visits fewer keys. Neither tree is
+// changed. In a multiset, a key held i times by b and j times by other is held
+// min(i, j) times, by the first of the values b holds for it, whether the
+// trees are walked in step or searched.
+func (b BTree[T]) Intersection(other *BTree[T]) *BTree[T] {
+	var (
+		small, large = b.size, other.size
+		keys         []T
+	)
+	if small > large {
+		small, large = large, small
+	}
+	switch {
+	case small*bits.Len(uint(large)) >= large:
+		walkInStep(&b, other, func(ka, _ T, inA, inB bool) bool {
+			if inA && inB {
+				keys = append(keys, ka)
+			}
+			return true
+		})
+	case b.size <= other.size:
+		probeInStep(&b, other, func(ka, _ T) {
+			keys = append(keys, ka)
+		})
+	default:
+		probeInStep(other, &b, func(_, kb T) {
+			keys = append(keys, kb)
+		})
+	}
+	i := b.emptyLike()
+	i.load(keys)
+	return i
+}
+
+// Difference returns a new tree configured like b holding the keys held by b
+// but not by other, where both trees are ordered alike. The trees are walked in
+// step, and the new tree built directly from the keys kept, taking O(n+m)
+// time without changing either tree.
+func (b BTree[T]) Difference(other *BTree[T]) *BTree[T] {
+	var keys []T
+	walkInStep(&b, other, func(ka, _ T, inA, inB bool) bool {
+		if inA && !inB {
+			keys = append(keys, ka)
+		}
+		return true
+	})
+	d := b.emptyLike()
+	d.load(keys)
+	return d
+}
+
+// Equal reports whether b and other hold the same keys, where both trees are
+// ordered alike, regardless of how the keys are laid out between their nodes.
+// In a multiset, each key must be held as many times by both trees. Trees of
+// different sizes are told apart at once, and otherwise the trees are walked
+// in step until the first key held by only one of them.
+func (b BTree[T]) Equal(other *BTree[T]) bool {
+	if b.size != other.size {
+		return false
+	}
+	equal := true
+	walkInStep(&b, other, func(_, _ T, inA, inB bool) bool {
+		equal = inA && inB
+		return equal
+	})
+	return equal
+}
+
+// emptyLike returns an empty tree with a configuration of its own, copied from
+// that of b.
+func (b BTree[T]) emptyLike() *BTree[T] {
+	cfg := *b.cfg
+	cfg.seq = 0
+	e := &BTree[T]{root: newRootLeafNode(&cfg), cfg: &cfg}
+	if b.recent != nil {
+		e.recent = newRecency[T](b.recent.capacity)
+	}
+	return e
+}
+
+// sibling returns an empty tree to take keys split from b, with a
+// configuration of its own copied from that of b, so that neither tree owns
+// the nodes of the other while sequence numbers carry on from those of b.
+func (b BTree[T]) sibling() *BTree[T] {
+	cfg := *b.cfg
+	return &BTree[T]{root: newRootLeafNode(&cfg), cfg: &cfg}
+}
+
+// Swap exchanges the contents of the tree with those of other in O(1) time,
+// leaving each a complete and independent tree. Used with a lock, it allows a
+// tree built elsewhere to replace another at once.
+func (b *BTree[T]) Swap(other *BTree[T]) {
+	b.root, other.root = other.root, b.root
+	b.cfg, other.cfg = other.cfg, b.cfg
+	b.size, other.size = other.size, b.size
+	b.version++
+	other.version++
+}
+
+// Checksum combines the hashes h of every key in the tree into a checksum
+// which doesn't depend on the shape of the tree, so that trees holding the same
+// keys have the same checksum. The hashes are combined by addition modulo 2⁶⁴
+// which, unlike XOR, doesn't cancel out pairs of keys with equal hashes. The
+// checksum is no stronger than h itself, and trees with different keys collide
+// whenever the sums of their hashes do. As h is only known at the time of the
+// call, Checksum visits every key in O(n) time.
+func (b BTree[T]) Checksum(h func(T) uint64) (sum uint64) {
+	b.root.ascend(func(k T) bool {
+		sum += h(k)
+		return true
+	})
+	return
+}
+
+// EqualsSlice reports whether the keys of the tree, in ascending order, match
+// those of sorted element by element, comparing them with Compare. The walk
+// stops at the first mismatch, and trees and slices of different lengths are
+// never equal.
+func (b BTree[T]) EqualsSlice(sorted []T) bool {
+	if len(sorted) != b.size {
+		return false
+	}
+	i := 0
+	return b.root.ascend(func(k T) bool {
+		i++
+		return b.cfg.compare(k, sorted[i-1]) == 0
+	})
+}
+
+// Within calls fn for each key k within radius of center, that is where
+// dist(center, k) ≤ radius, in ascending order until fn returns false. dist is
+// assumed to grow as keys lie further from center in the order of the tree, in
+// either direction, so that the keys within radius are contiguous. The first key
+// of the neighbourhood is found by walking down from center until a key falls
+// outside radius, and the walk stops at the first key after center which does,
+// so that only the neighbourhood and a key either side of it are visited.
+func (b BTree[T]) Within(center T, radius int, dist func(a, b T) int, fn func(T) bool) {
+	start := center
+	b.root.descendFrom(center, func(k T) bool {
+		if dist(center, k) > radius {
+			return false
+		}
+		start = k
+		return true
+	})
+	b.root.ascendFrom(start, func(k T) bool {
+		if b.cfg.compare(k, center) > 0 && dist(center, k) > radius {
+			return false
+		}
+		return fn(k)
+	})
+}
+
+// RemoveAll removes the value matching each of keys from the tree as Remove
+// would, and returns the number of keys removed. The keys are first sorted in
+// descending order, in a copy which leaves keys as it is, so that successive
+// removals descend through the nodes just left by the one before. Each key is
+// looked for before it's removed, so that keys not in the tree are passed over
+// without calling BeforeRemove or rearranging the tree on the way down. As with
+// Remove, each key removes only one value matching it from a multiset.
+func (b *BTree[T]) RemoveAll(keys ...T) int {
+	sorted := append([]T(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool { return b.cfg.compare(sorted[i], sorted[j]) > 0 })
+	size := b.size
+	for _, k := range sorted {
+		if _, found := b.root.search(k); found {
+			b.Remove(k)
+		}
+	}
+	return size - b.size
+}
+
+// RemoveRangeFunc removes every key k in the range from ≤ k < to from the tree,
+// calling onRemove with each stored value before it is removed, and returns the
+// number of keys removed. The keys in the range are collected in a single walk
+// of the range before any of them are removed.
+func (b *BTree[T]) RemoveRangeFunc(from, to T, onRemove func(T)) int {
+	var keys []T
+	b.root.ascendFrom(from, func(k T) bool {
+		if b.cfg.compare(k, to) >= 0 {
+			return false
+		}
+		keys = append(keys, k)
+		return true
+	})
+	for _, k := range keys {
+		onRemove(k)
+		b.Remove(k)
+	}
+	return len(keys)
+}
+
+// HasDuplicates reports whether any two keys next to one another in ascending
+// order compare equal. Outside of a multiset, no two keys in the tree should
+// ever compare equal, so a true result points to a tree corrupted by keys
+// whose order changed after they were inserted, or by an inconsistent Compare.
+// The keys are compared pairwise in a single walk of the tree, which stops at
+// the first duplicate.
+func (b BTree[T]) HasDuplicates() (found bool) {
+	var (
+		prev    T
+		started bool
+	)
+	b.root.ascend(func(k T) bool {
+		if started && b.cfg.compare(prev, k) == 0 {
+			found = true
+			return false
+		}
+		prev, started = k, true
+		return true
+	})
+	return
+}
+
+// RemoveRangeIf removes every key k in the range from ≤ k < to for which pred
+// returns true, and returns the number of keys removed. pred is only called
+// with the keys in the range, which are walked once to collect the matching
+// keys before any of them are removed.
+func (b *BTree[T]) RemoveRangeIf(from, to T, pred func(T) bool) int {
+	var (
+		keys  []T
+		ranks []int
+		rank  = rankOf[T](b.root, from, b.cfg.before)
+	)
+	b.root.ascendFrom(from, func(k T) bool {
+		if b.cfg.compare(k, to) >= 0 {
+			return false
+		}
+		if pred(k) {
+			keys, ranks = append(keys, k), append(ranks, rank)
+		}
+		rank++
+		return true
+	})
+	b.removeChosen(keys, ranks)
+	return len(keys)
+}
+
+// RemoveIf removes every key in the tree for which pred returns true, and
+// returns the number of keys removed. The whole tree is walked once to collect
+// the matching keys before any of them are removed, so that pred never sees a
+// tree in the middle of being changed, and each is then removed like any
+// other, leaving the tree balanced.
+func (b *BTree[T]) RemoveIf(pred func(T) bool) int {
+	var (
+		keys  []T
+		ranks []int
+		rank  int
+	)
+	b.root.ascend(func(k T) bool {
+		if pred(k) {
+			keys, ranks = append(keys, k), append(ranks, rank)
+		}
+		rank++
+		return true
+	})
+	b.removeChosen(keys, ranks)
+	return len(keys)
+}
+
+// removeChosen removes keys, chosen in a walk of the tree in ascending order,
+// where ranks holds the rank of each. In a multiset, Remove would remove the
+// first of the keys matching each, which needn't be the one chosen, so each is
+// instead removed by its rank, less the number of keys before it removed
+// already.
+func (b *BTree[T]) removeChosen(keys []T, ranks []int) {
+	for j, k := range keys {
+		if b.cfg.multiset {
+			b.RemoveAt(ranks[j] - j)
+		} else {
+			b.Remove(k)
+		}
+	}
+}
+
+// ArgMinInRange returns the key k in the range from ≤ k < to with the smallest
+// score, or false if the range holds no keys. Of keys with equal scores, the
+// first in ascending order is returned. As score has nothing to do with the
+// order of the tree, every key in the range is scored, but no others.
+func (b BTree[T]) ArgMinInRange(from, to T, score func(T) int) (T, bool) {
+	return b.argInRange(from, to, score, func(s, best int) bool { return s < best })
+}
+
+// ArgMaxInRange returns the key k in the range from ≤ k < to with the largest
+// score, in the same way as ArgMinInRange.
+func (b BTree[T]) ArgMaxInRange(from, to T, score func(T) int) (T, bool) {
+	return b.argInRange(from, to, score, func(s, best int) bool { return s > best })
+}
+
+// argInRange returns the first key in the range from ≤ k < to whose score no
+// later key's score beats.
+func (b BTree[T]) argInRange(from, to T, score func(T) int, beats func(s, best int) bool) (key T, found bool) {
+	var best int
+	b.root.ascendFrom(from, func(k T) bool {
+		if b.cfg.compare(k, to) >= 0 {
+			return false
+		}
+		if s := score(k); !found || beats(s, best) {
+			key, best, found = k, s, true
+		}
+		return true
+	})
+	return
+}
+
+// Histogram counts the keys of the tree into numBuckets buckets, numbered from
+// 0, in a single walk over the keys in ascending order, where bucket returns
+// the bucket of a key. Keys for which bucket returns a number outside of the
+// range of buckets are dropped, rather than counted in the first or last. If
+// numBuckets ≤ 0 there are no buckets to count into, so Histogram returns nil
+// without walking the tree.
+func (b BTree[T]) Histogram(bucket func(T) int, numBuckets int) []int {
+	if numBuckets <= 0 {
+		return nil
+	}
+	counts := make([]int, numBuckets)
+	b.root.ascend(func(k T) bool {
+		if i := bucket(k); i >= 0 && i < numBuckets {
+			counts[i]++
+		}
+		return true
+	})
+	return counts
+}
+
+// DescendByCount calls fn with each distinct key in the tree and the number of
+// keys comparing equal to it, in descending order of that count, until fn
+// returns false. Keys with the same count are visited in ascending order. The
+// counts are collected in a single walk of the tree counting runs of equal
+// keys, which are then sorted by count. Outside of a multiset, every key is
+// distinct and so has a count of 1.
+func (b BTree[T]) DescendByCount(fn func(key T, count int) bool) {
+	type run struct {
+		key   T
+		count int
+	}
+	var runs []run
+	b.root.ascend(func(k T) bool {
+		if last := len(runs) - 1; last >= 0 && b.cfg.compare(runs[last].key, k) == 0 {
+			runs[last].count++
+			return true
+		}
+		runs = append(runs, run{k, 1})
+		return true
+	})
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].count > runs[j].count
+	})
+	for _, r := range runs {
+		if !fn(r.key, r.count) {
+			return
+		}
+	}
+}
+
+// IsSubset reports whether every key in sub is also in super, where both trees
+// are ordered alike. The trees are walked side by side in a single merge of
+// their keys, taking O(n+m) time, with super advanced up to each key of sub in
+// turn. The walk fails as soon as super passes a key of sub without matching
+// it, or runs out of keys, and not at all if sub holds more keys than super.
+func IsSubset[T any](sub, super *BTree[T]) bool {
+	if sub.size > super.size {
+		return false
+	}
+	c := newCursor[T](super.root)
+	return sub.root.ascend(func(k T) bool {
+		for {
+			s, ok := c.next()
+			if !ok {
+				return false
+			}
+			switch compared := super.cfg.compare(s, k); {
+			case compared == 0:
+				return true
+			case compared > 0:
+				return false
+			}
+		}
+	})
+}
+
+// CommonPrefixLen returns the number of keys at the start of a and b, in
+// ascending order, which the two trees share before their keys first differ,
+// where both trees are ordered alike. The trees are walked in step, stopping
+// at the first pair of keys which don't compare equal.
+func CommonPrefixLen[T any](a, b *BTree[T]) int {
+	var (
+		ca = newCursor[T](a.root)
+		cb = newCursor[T](b.root)
+		n  = 0
+	)
+	for {
+		ka, oka := ca.next()
+		kb, okb := cb.next()
+		if !oka || !okb || a.cfg.compare(ka, kb) != 0 {
+			return n
+		}
+		n++
+	}
+}
+
+// ChangeKind is the kind of change between two trees reported by ChangedSince.
+type ChangeKind int
+
+const (
+	Added    ChangeKind = iota // A key is in the current tree but not the old
+	Removed                    // A key is in the old tree but not the current
+	Modified                   // A key is in both trees with differing values
+)
+
+// ChangedSince calls fn for each key which differs between old and cur in
+// ascending order, until fn returns false, where old is typically a copy of cur
+// taken before it was changed and both trees are ordered alike. Added keys are
+// reported with the zero value of T as old, and removed keys with it as new.
+// Keys in both trees are reported as Modified where equal reports that their
+// values differ, or never if equal is nil. The two trees are walked in step,
+// taking time in proportion to the keys of both.
+func ChangedSince[T any](old, cur *BTree[T], equal func(a, b T) bool, fn func(kind ChangeKind, old, new T) bool) {
+	walkInStep(old, cur, func(ko, kc T, inOld, inCur bool) bool {
+		switch {
+		case !inCur:
+			return fn(Removed, ko, kc)
+		case !inOld:
+			return fn(Added, ko, kc)
+		case equal != nil && !equal(ko, kc):
+			return fn(Modified, ko, kc)
+		}
+		return true
+	})
+}
+
+// Separators calls fn with each key held by the internal nodes of the tree, in
+// ascending order, until fn returns false. level is the depth of the node
+// holding the key, where the root is at level 0. These keys separate the keys
+// of the nodes below them, and make a sample of the keys of the tree spread
+// roughly evenly across them, with fewer keys at each level closer to the
+// root. Leaves are never visited, so the walk takes time in proportion to
+// roughly 1/t of the keys.
+func (b BTree[T]) Separators(fn func(level int, key T) bool) {
+	var walk func(n node[T], level int) bool
+	walk = func(n node[T], level int) bool {
+		keys, children := n.contents()
+		if children == nil {
+			return true
+		}
+		for i, k := range keys {
+			if !walk(children[i], level+1) || !fn(level, k) {
+				return false
+			}
+		}
+		return walk(children[len(keys)], level+1)
+	}
+	walk(b.root, 0)
+}
+
+// NodesPerLevel returns the number of nodes at each level of the tree, from the
+// root at index 0 down to the leaves, showing how widely the tree branches at
+// each level. The tree is walked breadth first, one level at a time.
+func (b BTree[T]) NodesPerLevel() []int {
+	var (
+		counts []int
+		level  = []node[T]{b.root}
+	)
+	for len(level) > 0 {
+		counts = append(counts, len(level))
+		var below []node[T]
+		for _, n := range level {
+			_, children := n.contents()
+			for _, child := range children {
+				below = append(below, child)
+			}
+		}
+		level = below
+	}
+	return counts
+}
+
+// SplitTopK moves the k largest keys in the tree into a new tree which it
+// returns, keeping the remaining keys in b. Rather than removing keys one by
+// one, the tree is cut in two along the path to the position of the cut,
+// found from the number of keys held beneath each node, in O(logₜn) time if
+// the tree keeps order statistics, and O(n) time if it doesn't. If k ≤ 0 the returned tree is empty, while if k is at least the number of keys
+// in the tree, every key is moved, leaving b empty.
+// The returned tree has a configuration of its own, copied from that of b, so
+// that a change to either tree never touches a node reachable from the other.
+func (b *BTree[T]) SplitTopK(k int) *BTree[T] {
+	top := b.sibling()
+	switch {
+	case k <= 0:
+		return top
+	case k >= b.size:
+		b.Swap(top)
+		return top
+	}
+	left, right := splitAt(b.root.mutable(b.cfg).asChild(), b.size-k)
+	if b.cfg.linkLeaves {
+		if last := left.lastLeaf(); last != nil {
+			last.next = nil
+		}
+		if first := right.firstLeaf(); first != nil {
+			first.prev = nil
+		}
+	}
+	b.root, b.size = left.asRoot(), b.size-k
+	b.version++
+	top.root, top.size = right.asRoot(), k
+	return top
+}
+
+// Split cuts the tree in two about pivot, returning a tree holding the keys less
+// than pivot and another holding the rest, and leaving b empty. As with
+// SplitTopK, the tree is cut along the path to pivot rather than being
+// rebuilt, with the position of the cut found from the number of keys held
+// beneath each node, taking O(logₜn) time in all with OrderStatistics, or
+// O(n) time without, as the keys beneath each node are then counted as the
+// cut passes them. Both trees are valid, and
+// neither keeps the hooks of b. As with SplitTopK, each tree has a
+// configuration of its own, and so does b once emptied, so that none of the
+// three changes a node reachable from another.
+func (b *BTree[T]) Split(pivot T) (left, right *BTree[T]) {
+	right = b.SplitTopK(b.size - rankOf[T](b.root, pivot, b.cfg.lower))
+	left = b.sibling()
+	b.Swap(left)
+	return left, right
+}
+
+// Concat joins left and right into a single tree which it returns, leaving both
+// empty, where every key in left is less than every key in right. The two
+// trees must be configured alike, and Concat panics if they aren't, or if their
+// keys overlap; in a multiset, the last key of left may equal the first of
+// right. Rather than inserting the keys of one tree into the other, the first
+// key of right is taken out to stand between the two, and the shorter tree is
+// attached beside the node of the same height along the inner spine of the
+// taller, taking O(logₜn) time. The joined tree has a configuration of its
+// own, copied from that of left, unless left is empty, and keys keep their
+// sequence numbers. The nodes of neither tree belong to the joined tree, which
+// so copies them on write as it would those of a clone, leaving any clones of
+// left or right unchanged. The joined tree has none of the hooks of either
+// tree. Concat is the inverse of Split.
+func Concat[T any](left, right *BTree[T]) *BTree[T] {
+	var (
+		lc, rc = left.cfg, right.cfg
+		cfg    = *lc
+		joined = &BTree[T]{root: left.root, cfg: &cfg, size: left.size + right.size}
+	)
+	if lc.t != rc.t || lc.linkLeaves != rc.linkLeaves || lc.sequence != rc.sequence ||
+		lc.reversed != rc.reversed || lc.multiset != rc.multiset || lc.counted != rc.counted ||
+		(lc.weight == nil) != (rc.weight == nil) {
+		panic("btree: Concat of trees configured differently")
+	}
+	switch {
+	case left.size == 0:
+		cfg = *rc
+		joined.root = right.root
+	case right.size > 0:
+		if c := lc.compare(left.root.max(), right.root.min()); c > 0 || c == 0 && !lc.multiset {
+			panic("btree: Concat of trees whose keys overlap")
+		}
+		r := right.root.mutable(&cfg).asChild()
+		median := r.deleteSucc()
+		joined.root = join(&cfg, left.root.mutable(&cfg).asChild(), median, collapse(r)).asRoot()
+	}
+	cfg.seq = max(lc.seq, rc.seq)
+
+	left.root, left.size = newRootLeafNode(lc), 0
+	left.version++
+	right.root, right.size = newRootLeafNode(rc), 0
+	right.version++
+	return joined
+}
+
+// Reverse flips the order of the tree in place, so that Ascend then visits the
+// keys in what was descending order, Min and Max swap meaning, and Compare
+// reports the flipped order. Rather than consulting a direction flag in every
+// walk, Reverse rewrites each node once, reversing its keys and children, for a
+// one-time cost of O(n). Afterwards, keys are compared with the order of Compare
+// negated, and every operation costs the same as before. Reversing the tree
+// again restores the original order.
+func (b *BTree[T]) Reverse() {
+	cfg := *b.cfg
+	cfg.reversed = !cfg.reversed
+
+	// Nodes split away from b into other trees may still hold its
+	// configuration, so the reversed tree is given a configuration of its own
+	// rather than changing that one.
+	b.root = b.root.mutable(b.cfg)
+	if root, ok := b.root.(*rootInternalNode[T]); ok {
+		root.own()
+	}
+	b.cfg = &cfg
+	b.root.reverse(b.cfg)
+	b.version++
+}
+
+// EstimateComparisons searches the tree for each of queries in turn, returning
+// the total number of times keys were compared, as a measure of the cost of a
+// workload for a given distribution of keys, without changing the tree. Each
+// query descends the tree as Search does, with a comparison of its own which
+// counts each call, so the tree may be read elsewhere meanwhile. It takes
+// O(len(queries)·logₜn) time.
+func (b BTree[T]) EstimateComparisons(queries []T) int {
+	count := 0
+	compare := func(a, c T) int {
+		count++
+		return b.cfg.compare(a, c)
+	}
+	for _, q := range queries {
+		var n node[T] = b.root
+		for {
+			keys, children := n.contents()
+			i, found := find(keys, q, compare)
+			if found || children == nil {
+				break
+			}
+			n = children[i]
+		}
+	}
+	return count
+}
+
+// Rank returns the number of keys in the tree less than key, the position key
+// has or would have in ascending order, counting from zero. Rank takes a
+// single descent of the tree, summing the sizes of the subtrees to the left of
+// the path to key, which each node records if the tree was created with
+// OrderStatistics.
+func (b BTree[T]) Rank(key T) int {
+	return rankOf[T](b.root, key, b.cfg.lower)
+}
+
+// Select returns the i-th smallest key in the tree, counting from zero, or
+// false if i is out of range, in a single descent of the tree guided by the
+// number of keys held beneath each node, which takes O(logₜn) time with
+// OrderStatistics, and O(n) time without.
+func (b BTree[T]) Select(i int) (key T, found bool) {
+	if i < 0 || i >= b.size {
+		return
+	}
+	return keyAt[T](b.root, i), true
+}
+
+// SearchPosition returns the position key has or would have among the keys of
+// the tree in ascending order, counting from zero, along with whether a value
+// matching key is held there. The position is a global one, the same as that
+// reported by Rank, rather than one within any node, so it can be passed to
+// Select, or used to compute offsets into a range of keys. In a multiset, the
+// position is that of the first of the values matching key. Both are found in
+// a single descent of the tree, which sums the sizes of the subtrees to the
+// left of the path to key, and tracks the least key greater than or equal to
+// key so far, as Ceiling does. As for Rank, the descent takes O(logₜn) time
+// if the tree keeps order statistics, and O(n) time otherwise.
+func (b BTree[T]) SearchPosition(key T) (index int, found bool) {
+	var (
+		n    node[T] = b.root
+		next T
+		has  bool
+	)
+	for {
+		keys, children := n.contents()
+		i, ok := find(keys, key, b.cfg.lower)
+		if i < len(keys) {
+			next, has = keys[i], true
+		}
+		if children == nil {
+			return index + i, ok || has && b.cfg.compare(next, key) == 0
+		}
+		for _, child := range children[:i] {
+			index += child.size()
+		}
+		index += i
+		if ok {
+			return index + children[i].size(), true
+		}
+		n = children[i]
+	}
+}
+
+// RemoveAt removes and returns the i-th smallest key in the tree, counting
+// from zero, or returns false if i is out of range. The key is removed in a
+// single descent guided by the number of keys held beneath each node, which
+// rearranges the tree on the way down as Remove does, taking O(logₜn) time
+// with OrderStatistics, and O(n) time without, when those numbers are counted
+// as the descent goes. In
+// a multiset, it's the i-th key itself which is removed, rather than any key
+// equal to it.
+func (b *BTree[T]) RemoveAt(i int) (key T, removed bool) {
+	if i < 0 || i >= b.size {
+		return
+	}
+	if b.BeforeRemove != nil {
+		b.BeforeRemove(keyAt[T](b.root, i))
+	}
+	return b.remove(&seek[T]{rank: i, byRank: true})
+}
+
+// IndexRange returns the indices [lo, hi), counting from zero in ascending
+// order, of the keys k in the range from ≤ k < to, so that a range of keys can
+// be mapped onto offsets of a slice holding the keys of the tree in order. lo
+// is the number of keys less than from, and hi the number less than to, each
+// found in a descent guided by the number of keys held beneath each node,
+// taking O(logₜn) time if the tree keeps order statistics, and O(n) time if it
+// doesn't. lo and hi are equal when the range holds no keys.
+func (b BTree[T]) IndexRange(from, to T) (lo, hi int) {
+	lo = rankOf[T](b.root, from, b.cfg.before)
+	hi = rankOf[T](b.root, to, b.cfg.before)
+	if hi < lo {
+		hi = lo
+	}
+	return
+}
+
+// CountRange returns the number of keys k in the range lo ≤ k ≤ hi, inclusive
+// at both ends, without visiting them. The number of keys not greater than hi
+// and the number less than lo are each found in a descent guided by the number
+// of keys held beneath each node, taking O(logₜn) time in all with
+// OrderStatistics, or O(n) time without. If lo > hi, the
+// range holds no keys.
+func (b BTree[T]) CountRange(lo, hi T) int {
+	if b.cfg.compare(lo, hi) > 0 {
+		return 0
+	}
+	return rankOf[T](b.root, hi, b.cfg.after) - rankOf[T](b.root, lo, b.cfg.before)
+}
+
+// EstimateCountRange estimates the number of keys k in the range from ≤ k < to,
+// such as to judge the selectivity of a query, by descending only the top two
+// levels of the tree towards each end of the range. Each end is assumed to lie
+// halfway through the subtree it belongs in beneath those levels, so the
+// estimate is off by at most the size of one such subtree at either end, and
+// is exact for trees of no more than two levels.
+func (b BTree[T]) EstimateCountRange(from, to T) int {
+	var (
+		lo = rankWithin[T](b.root, from, b.cfg.before, estimateDepth)
+		hi = rankWithin[T](b.root, to, b.cfg.before, estimateDepth)
+	)
+	if hi < lo {
+		return 0
+	}
+	return hi - lo
+}
+
+// EqualRange returns the indices [first, last), counting from zero in ascending
+// order, of the run of keys which coarse reports as equal to probe, along with
+// count, the number of keys in the run. coarse must agree with the order of
+// the tree, only ever reporting as equal keys which are next to one another,
+// such as where it compares timestamps by day, so that the run is contiguous.
+// Its bounds are found in two descents guided by the number of keys held
+// beneath each node, one for each end of the run, taking O(logₜn) time if the
+// tree keeps order statistics, as a multiset always does, and O(n) otherwise.
+func (b BTree[T]) EqualRange(probe T, coarse func(a, b T) int) (first, last, count int) {
+	first = rankOf[T](b.root, probe, func(a, c T) int {
+		if compared := coarse(a, c); compared != 0 {
+			return compared
+		}
+		return -1
+	})
+	last = rankOf[T](b.root, probe, func(a, c T) int {
+		if compared := coarse(a, c); compared != 0 {
+			return compared
+		}
+		return 1
+	})
+	return first, last, last - first
+}
+
+// rankOf returns the number of keys in the subtree rooted at n less than k.
+func rankOf[T any](n node[T], k T, compare func(a, b T) int) int {
+	return rankWithin(n, k, compare, math.MaxInt)
+}
+
+// rankWithin returns the number of keys in the subtree rooted at n less than
+// k, descending at most depth levels of the subtree. Where the descent stops
+// short of a leaf, k is taken to lie halfway through the subtree it belongs in.
+func rankWithin[T any](n node[T], k T, compare func(a, b T) int, depth int) (rank int) {
+	for ; depth > 0; depth-- {
+		keys, children := n.contents()
+		i, found := find(keys, k, compare)
+		if children == nil {
+			return rank + i
+		}
+		for _, child := range children[:i] {
+			rank += child.size()
+		}
+		rank += i
+		if found {
+			return rank + children[i].size()
+		}
+		n = children[i]
+	}
+	return rank + n.size()/2
+}
+
+// keyAt returns the i-th key in order of the subtree rooted at n, which must
+// be in range.
+func keyAt[T any](n node[T], i int) T {
+	for {
+		keys, children := n.contents()
+		if children == nil {
+			return keys[i]
+		}
+		j := 0
+		for ; j < len(keys); j++ {
+			size := children[j].size()
+			if i < size {
+				break
+			}
+			if i == size {
+				return keys[j]
+			}
+			i -= size + 1
+		}
+		n = children[j]
+	}
+}
+
+// SelectByWeight returns the first key, in ascending order, at which the
+// running total of the weights of the keys, as weighed by weight, reaches or
+// exceeds target, or false if the weights of all the keys of the tree fall
+// short of it. If the tree was created with a Weight, each node records the
+// total weight of the keys beneath it, so that a single descent skips any
+// child whose total falls short of what's left of target, taking O(logₜn)
+// time; weight is then ignored, and may be nil. Otherwise the keys are scanned
+// in ascending order, weighing each in turn with weight, taking O(n) time.
+// SelectByWeight panics if the tree doesn't weigh its keys and weight is nil.
+func (b BTree[T]) SelectByWeight(target int, weight func(T) int) (key T, found bool) {
+	if b.cfg.weight == nil {
+		if weight == nil {
+			panic("btree: SelectByWeight called without a weight on a tree without a Weight")
+		}
+		sum := 0
+		b.root.ascend(func(k T) bool {
+			if sum += weight(k); sum >= target {
+				key, found = k, true
+			}
+			return !found
+		})
+		return key, found
+	}
+	if b.size == 0 || b.root.sum() < target {
+		return key, false
+	}
 
-func NewBTree[T Comparable[T]]() *BTree[T] {
-	return &BTree[T]{newRootLeafNode[T]()}
+	// The subtree rooted at n always holds enough weight to reach target, so
+	// the key is found before the end of any leaf.
+	var n node[T] = b.root
+	for {
+		keys, children := n.contents()
+		j := 0
+		for ; j < len(keys); j++ {
+			if children != nil {
+				sum := children[j].sum()
+				if target <= sum {
+					break
+				}
+				target -= sum
+			}
+			target -= b.cfg.weight(keys[j])
+			if target <= 0 {
+				return keys[j], true
+			}
+		}
+		n = children[j]
+	}
 }
 
-// Search searches the tree recursively for the value matching key if such a
-// value exists.
-func (b BTree[T]) Search(key T) (T, bool) {
-	return b.root.search(key)
+// node represents functionality common to all nodes in the B-tree. All nodes
+// implement node in addition to one of rootNode or childNode.
+type node[T any] interface {
+	isAboveMin() bool                                   // Returns true if the degree of node is
+	isBelowMax() bool                                   // Returns true if a node is not full
+	search(T) (T, bool)                                 // Searches the subtree rooted at a node for a key
+	insertBelowMax(item[T], bool, *Cursor[T]) (T, bool) // Inserts a key into the subtree rooted at a non-full node
+	remove(*seek[T]) (T, bool)                          // Removes the key sought from the subtree rooted a node
+	ascend(func(T) bool) bool                           // Visits keys in the subtree in order until told to stop
+	ascendFrom(T, func(T) bool) bool                    // Visits keys from a given key onwards until told to stop
+	ascendItems(func(item[T]) bool) bool                // Visits keys with their sequence numbers until told to stop
+	descend(func(T) bool) bool                          // Visits keys in the subtree in reverse order until told to stop
+	descendFrom(T, func(T) bool) bool                   // Visits keys from a given key downwards until told to stop
+	searchNeighbours(T, *neighbours[T]) (T, bool)       // Searches for a key and the keys either side
+	min() T                                             // Returns the first key in the subtree rooted at a node
+	max() T                                             // Returns the last key in the subtree rooted at a node
+	firstLeaf() *childLeafNode[T]                       // Returns the leftmost leaf below the root in the subtree
+	lastLeaf() *childLeafNode[T]                        // Returns the rightmost leaf below the root in the subtree
+	size() int                                          // Returns the number of keys in the subtree rooted at a node
+	sum() int                                           // Returns the total weight of the keys in the subtree rooted at a node
+	reverse(*config[T])                                 // Reverses the order of the subtree, adopting a new configuration
+	contents() (list[T], list[childNode[T]])            // Returns the keys and any children of a node
 }
 
-// Insert inserts key into the tree or updates an existing value matching key
-// if such a value exists.
-func (b *BTree[T]) Insert(key T) {
-	if !b.root.isBelowMax() {
-		var (
-			root    = b.root.asChild()
-			newRoot = newRootInternalNode[T]()
-		)
-
-		// New values are always placed inside a leaf node. The insert operation
-		// recurses down tree in a single pass, searching for the appropriate
-		// position in the appropriate leaf node in which to place the value.
-		// To guarantee that there is always enough space to place new values;
-		// that recursion never descends into a full node; such nodes are split
-		// about their median key as they are encountered.
-		//
-		// Here, the root node of a B-Tree with t = 4 becomes the first child of
-		// a new node created from the the median key H, increasing the height
-		// of the tree by 1:
-		//
-		// root
-		// (A D F  H L N P)
-		// ↓ ↓ ↓ ↓  ↓ ↓ ↓ ↓
-		// T₁T₂T₃T₄ T₁T₂T₃T₄
-		//
-		// The root node originally has and 7 keys (2t-1) and 8 (2t) children.
-		// Splitting results in the creation of a new node node which becomes
-		// the immediate right sibling of what is now the former root. The two
-		// siblings each now have 3 (t-1) keys and 4 (t) children:
-		//
-		//       newRoot
-		//       (H)
-		//       ↓ ↓
-		// root     sibling
-		// (A D F)  (L N P)
-		// ↓ ↓ ↓ ↓  ↓ ↓ ↓ ↓
-		// T₁T₂T₃T₄ T₁T₂T₃T₄
-		medianKey, sibling := root.split()
-		newRoot.keys.insert(0, medianKey)
-		newRoot.children.insert(0, root)
-		newRoot.children.insert(1, sibling)
-		b.root = newRoot
-	}
-	b.root.insertBelowMax(key)
+// keysOf returns the keys of the node n, which may be changed in place.
+func keysOf[T any](n node[T]) *nodeKeys[T] {
+	switch n := n.(type) {
+	case *rootLeafNode[T]:
+		return &n.nodeKeys
+	case *childLeafNode[T]:
+		return &n.nodeKeys
+	case *rootInternalNode[T]:
+		return &n.nodeKeys
+	case *childInternalNode[T]:
+		return &n.nodeKeys
+	}
+	panic("unreachable")
 }
 
-// Remove removes the value matching key from the the tree if such a value
-// exists, and may result in the shrinking of the tree.
-func (b *BTree[T]) Remove(key T) {
-
-	// Like with insertion, removal recurses down the tree in a single pass,
-	// rearranging the tree as it goes to maintain its invariants. Unlike
-	// insertion, keys can be removed from leaf nodes or internal nodes. Now,
-	// care must be taken to ensure that recursion doesn't descend into a node
-	// that is too small, rather than one that is too big. This is done by
-	// shuffling spare keys between siblings, or merging siblings if necessary.
-	b.root.remove(key)
-	if !b.root.isAboveMin() {
-
-		// Further, in contrast to the case of insertion into a B-Tree rooted at
-		// a full node, where the height of tree increases by 1; removal from a
-		// B-Tree may result in an empty internal root node, in which case the
-		// first child of the root becomes the new root of the tree.
-		//
-		// Here, D is removed from a tree with t = 3. The first child of the
-		// root becomes the new root of the tree, decreasing the height of the
-		// tree by 1.
-		//
-		//                     root
-		//                     (P)
-		//                    ↓  ↓
-		//     first child             second child
-		//     (C        L)            (T     X)
-		//     ↓     ↓    ↓            ↓   ↓   ↓
-		// (A B) (D E J K) (N 0) (Q R S) (U V) (Y Z)
-		//
-		// Both children of the root node each with 2 (t-1) keys and 3 (t)
-		// children, are too small for recursion to continue. The siblings are
-		// merged about P, which moves down from the root to become the median
-		// key of the merged node with 5 (2t -1) keys and 6 (t) children, which
-		// becomes new root of the tree.
-		//
-		//     new root
-		//     (C       L     P       T     X)
-		//     ↓    ↓      ↓      ↓      ↓   ↓
-		// (A B) (E J K) (N 0) (Q R S) (U V) (Y Z)
-		b.root = b.root.shrink()
+// internalOf returns the internal node n as a baseInternalNode, which may be
+// changed in place, or nil if n is a leaf.
+func internalOf[T any](n node[T]) *baseInternalNode[T] {
+	switch n := n.(type) {
+	case *rootInternalNode[T]:
+		return &n.baseInternalNode
+	case *childInternalNode[T]:
+		return &n.baseInternalNode
 	}
+	return nil
 }
 
-// node represents functionality common to all nodes in the B-tree. All nodes
-// implement node in addition to one of rootNode or childNode.
-type node[T Comparable[T]] interface {
-	isAboveMin() bool   // Returns true if the degree of node is
-	isBelowMax() bool   // Returns true if a node is not full
-	search(T) (T, bool) // Searches the subtree rooted at a node for a key
-	insertBelowMax(T)   // Inserts a key into the subtree rooted at a non-full node
-	remove(T)           // Removes a key from the subtree rooted a node
+// neighbours records the keys seen either side of some key k as a search
+// descends the tree. prev is the largest key less than k and next is the
+// smallest key greater than k.
+type neighbours[T any] struct {
+	prev, next       T
+	hasPrev, hasNext bool
 }
 
-type baseLeafNode[T Comparable[T]] struct {
-	keys list[T]
+type baseLeafNode[T any] struct {
+	cfg *config[T]
+	nodeKeys[T]
 }
 
-func newBaseLeafNode[T Comparable[T]]() baseLeafNode[T] {
-	return baseLeafNode[T]{newList[T](2*t - 1)}
+func newBaseLeafNode[T any](cfg *config[T]) baseLeafNode[T] {
+	return baseLeafNode[T]{cfg, newNodeKeys[T](2*cfg.t-1, cfg.sequence, cfg.weight)}
 }
 
 // search searches  a leaf node just reports if the key is contained within its
 // local list of keys.
 func (n baseLeafNode[T]) search(key T) (outkey T, found bool) {
-	i, found := find(n.keys, key)
+	i, found := n.cfg.probe(n.keys, nil, key)
 	if found {
 		return n.keys[i], true
 	}
 	return
 }
 
+// searchNeighbours searches the leaf node n for the value matching k, recording
+// the keys either side of it in nb. Keys found in parent nodes are left in nb
+// where n holds no closer key.
+func (n baseLeafNode[T]) searchNeighbours(k T, nb *neighbours[T]) (outkey T, found bool) {
+	i, found := n.cfg.probe(n.keys, nil, k)
+	if i > 0 {
+		nb.prev, nb.hasPrev = n.keys[i-1], true
+	}
+	j := i
+	if found {
+		outkey = n.keys[i]
+		j++
+	}
+	if j < len(n.keys) {
+		nb.next, nb.hasNext = n.keys[j], true
+	}
+	return
+}
+
 // insertBelowMax is called to insert a called at the end, the simple case when
-// recursion terminates by inserting k into is local key list.
-func (n *baseLeafNode[T]) insertBelowMax(k T) {
-	i, found := find(n.keys, k)
+// recursion terminates by inserting it into is local key list. If it matches
+// an existing value, that value is returned, and replaced only if replace is
+// true. The position of it is found with the guidance of hint, if not nil.
+func (n *baseLeafNode[T]) insertBelowMax(it item[T], replace bool, hint *Cursor[T]) (old T, found bool) {
+	i, found := hint.place(n.cfg, n.keys, it.key)
+	hint.took(i)
 	if found {
-		n.keys[i] = k
-		return
+		old = n.keys[i]
+		if replace {
+			n.put(i, it.key)
+		}
+		return old, true
+	}
+	n.insertAt(i, it)
+	return
+}
+
+// remove removes the key sought by s from the leaf node n if there is such a
+// key, returning the removed value.
+func (n *baseLeafNode[T]) remove(s *seek[T]) (old T, removed bool) {
+	i, found := s.in(n.cfg, n.keys, nil)
+	if found {
+		return n.removeAt(i).key, true
+	}
+	return
+}
+
+// ascend calls fn on each key of the leaf node n in order, returning false if
+// fn returned false to stop the walk.
+func (n baseLeafNode[T]) ascend(fn func(T) bool) bool {
+	for _, k := range n.keys {
+		if !fn(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// ascendItems calls fn on each key of the leaf node n in order, along with its
+// sequence number, returning false if fn returned false to stop the walk.
+func (n baseLeafNode[T]) ascendItems(fn func(item[T]) bool) bool {
+	for i := range n.keys {
+		if !fn(n.at(i)) {
+			return false
+		}
+	}
+	return true
+}
+
+// ascendFrom calls fn on each key of the leaf node n greater than or equal to
+// k in order, returning false if fn returned false to stop the walk.
+func (n baseLeafNode[T]) ascendFrom(k T, fn func(T) bool) bool {
+	i, _ := find(n.keys, k, n.cfg.lower)
+	for _, k := range n.keys[i:] {
+		if !fn(k) {
+			return false
+		}
+	}
+	return true
+}
+
+// descend calls fn on each key of the leaf node n in reverse order, returning
+// false if fn returned false to stop the walk.
+func (n baseLeafNode[T]) descend(fn func(T) bool) bool {
+	for i := len(n.keys) - 1; i >= 0; i-- {
+		if !fn(n.keys[i]) {
+			return false
+		}
 	}
-	n.keys.insert(i, k)
+	return true
 }
 
-// remove removes the value matching k from the leaf node n such a value exists.
-func (n *baseLeafNode[T]) remove(k T) {
-	i, found := find(n.keys, k)
+// descendFrom calls fn on each key of the leaf node n less than or equal to k
+// in reverse order, returning false if fn returned false to stop the walk.
+func (n baseLeafNode[T]) descendFrom(k T, fn func(T) bool) bool {
+	i, found := find(n.keys, k, n.cfg.upper)
 	if found {
-		n.keys.remove(i)
+		i++
+	}
+	for i--; i >= 0; i-- {
+		if !fn(n.keys[i]) {
+			return false
+		}
 	}
+	return true
+}
+
+// min returns the first key in the leaf node n.
+func (n baseLeafNode[T]) min() T {
+	return n.keys[0]
+}
+
+// max returns the last key in the leaf node n.
+func (n baseLeafNode[T]) max() T {
+	return n.keys[len(n.keys)-1]
+}
+
+// size returns the number of keys in the leaf node n.
+func (n baseLeafNode[T]) size() int {
+	return len(n.keys)
+}
+
+// sum returns the total weight of the keys in the leaf node n.
+func (n baseLeafNode[T]) sum() int {
+	return n.weight
+}
+
+// reverse reverses the keys of the leaf node n, which then takes on cfg.
+func (n *baseLeafNode[T]) reverse(cfg *config[T]) {
+	n.cfg = cfg
+	n.nodeKeys.reverse()
+}
+
+// clone returns a copy of the leaf node n belonging to the tree configured by
+// cfg, with keys of its own.
+func (n baseLeafNode[T]) clone(cfg *config[T]) baseLeafNode[T] {
+	return baseLeafNode[T]{cfg, n.nodeKeys.clone(2*cfg.t - 1)}
+}
+
+// contents returns the keys of the leaf node n, which has no children.
+func (n baseLeafNode[T]) contents() (list[T], list[childNode[T]]) {
+	return n.keys, nil
 }
 
-type baseInternalNode[T Comparable[T]] struct {
-	keys     list[T]
+// baseInternalNode holds the keys and children of an internal node, along with
+// count, the number of keys in the subtree rooted at the node, and total,
+// their total weight. Both are kept up to date as keys are inserted into and
+// removed from the subtree, and as keys and children move between siblings.
+type baseInternalNode[T any] struct {
+	cfg *config[T]
+	nodeKeys[T]
 	children list[childNode[T]]
+	count    int
+	total    int
 }
 
-func newBaseInternalNode[T Comparable[T]]() baseInternalNode[T] {
+func newBaseInternalNode[T any](cfg *config[T]) baseInternalNode[T] {
 	return baseInternalNode[T]{
-		newList[T](2*t - 1),
-		newList[childNode[T]](2 * t)}
+		cfg:      cfg,
+		nodeKeys: newNodeKeys[T](2*cfg.t-1, cfg.sequence, cfg.weight),
+		children: newList[childNode[T]](2 * cfg.t)}
 }
 
 // search recursively searches the subtree rooted at the internal node n for
 // for the value matching k.
 func (n baseInternalNode[T]) search(k T) (T, bool) {
-	i, found := find(n.keys, k)
+	i, found := n.cfg.probe(n.keys, n.children, k)
 	if found {
 		return n.keys[i], true
 	}
 	return n.children[i].search(k)
 }
 
-// insertBelowMax inserts k into the subtree rooted a the internal node n, or
-// updates the value matching k if such a value already exists.
-func (n *baseInternalNode[T]) insertBelowMax(k T) {
-	i, found := find(n.keys, k)
+// searchNeighbours recursively searches the subtree rooted at the internal
+// node n for the value matching k, recording the keys either side of it in nb.
+// When k matches a key of n, its neighbours are the last key of the child to
+// its left and the first key of the child to its right.
+func (n baseInternalNode[T]) searchNeighbours(k T, nb *neighbours[T]) (T, bool) {
+	i, found := n.cfg.probe(n.keys, n.children, k)
 	if found {
-		n.keys[i] = k
-		return
+		nb.prev, nb.hasPrev = n.children[i].max(), true
+		nb.next, nb.hasNext = n.children[i+1].min(), true
+		return n.keys[i], true
+	}
+	if i > 0 {
+		nb.prev, nb.hasPrev = n.keys[i-1], true
+	}
+	if i < len(n.keys) {
+		nb.next, nb.hasNext = n.keys[i], true
+	}
+	return n.children[i].searchNeighbours(k, nb)
+}
+
+// insertBelowMax inserts it into the subtree rooted a the internal node n, or
+// finds the value matching it if such a value already exists, returning that
+// value and replacing it only if replace is true. As for a leaf, the descent is
+// guided by hint, if not nil.
+func (n *baseInternalNode[T]) insertBelowMax(it item[T], replace bool, hint *Cursor[T]) (old T, found bool) {
+	i, found := hint.place(n.cfg, n.keys, it.key)
+	if found {
+		hint.took(i)
+		old = n.keys[i]
+		if replace {
+			n.put(i, it.key)
+			n.replaced(old, it.key)
+		}
+		return old, true
 	}
 
-	child := n.children[i]
+	child := n.mutableChild(i)
 	if !child.isBelowMax() {
-		medianKey, newChild := child.split()
-		n.keys.insert(i, medianKey)
+		median, newChild := child.split()
+		n.insertAt(i, median)
 		n.children.insert(i+1, newChild)
 
-		if k.Compare(n.keys[i]) > 0 {
+		// The median key moved up from the child may itself be the value
+		// matching it.
+		compared := n.cfg.placed(it.key, n.keys[i])
+		if compared == 0 {
+			hint.took(i)
+			old = n.keys[i]
+			if replace {
+				n.put(i, it.key)
+				n.replaced(old, it.key)
+			}
+			return old, true
+		}
+		if compared > 0 {
 			child = newChild
+			i++
 		}
 	}
-	child.insertBelowMax(k)
+	hint.took(i)
+	old, found = child.insertBelowMax(it, replace, hint)
+	if !found {
+		n.grew(it.key, nil)
+	} else if replace {
+		n.replaced(old, it.key)
+	}
+	return
 }
 
-// remove removes k from the subtree rooted at the internal node n.
-func (n *baseInternalNode[T]) remove(k T) {
+// remove removes the key sought by s from the subtree rooted at the internal
+// node n, returning the removed value. A seek by rank follows the key it seeks
+// as keys move into child ahead of it, and once the key itself has moved down
+// into child, seeks it at the end of the keys child held before.
+func (n *baseInternalNode[T]) remove(s *seek[T]) (old T, removed bool) {
 	var (
-		i, found = find(n.keys, k)
-		child    = n.children[i]
+		i, found = s.in(n.cfg, n.keys, n.children)
+		child    = n.mutableChild(i)
 	)
 
+	// Any sibling of child which is to be changed, by giving up keys to child
+	// or by merging with it, is first made to belong to the tree.
 	if found {
 		if child.isAboveMin() {
-			n.keys[i] = child.deletePred()
-			return
+			old = n.keys[i]
+			n.set(i, child.deletePred())
+			n.shrank(old, nil)
+			return old, true
 		}
-		if n.children[i+1].isAboveMin() {
-			n.keys[i] = n.children[i+1].deleteSucc()
-			return
+		right := n.mutableChild(i + 1)
+		if right.isAboveMin() {
+			old = n.keys[i]
+			n.set(i, right.deleteSucc())
+			n.shrank(old, nil)
+			return old, true
+		}
+		if s.byRank {
+			s.rank = child.size()
 		}
-		child.merge(n.keys.remove(i), n.children[i+1])
+		child.merge(n.removeAt(i), right)
 		n.children.remove(i + 1)
 	} else if child.isAboveMin() {
 
@@ -252,11 +2560,19 @@ func (n *baseInternalNode[T]) remove(k T) {
 		//     (E       L     P       T     X)
 		//     ↓    ↓      ↓      ↓      ↓   ↓
 		// (A C) (  J K) (N O) (Q R S) (U V) (Y Z)
-		stolen := n.keys.remove(i - 1)
-		n.keys.insert(i-1, child.shuffleRight(stolen, n.children[i-1]))
+		stolen := n.removeAt(i - 1)
+		n.insertAt(i-1, child.shuffleRight(stolen, n.mutableChild(i-1)))
+		if _, children := child.contents(); s.byRank {
+
+			// child gained stolen, and with it the subtree now before it.
+			s.rank++
+			if children != nil {
+				s.rank += children[0].size()
+			}
+		}
 	} else if i < len(n.keys) && n.children[i+1].isAboveMin() {
-		stolenKey := n.keys.remove(i)
-		n.keys.insert(i, child.shuffleLeft(stolenKey, n.children[i+1]))
+		stolen := n.removeAt(i)
+		n.insertAt(i, child.shuffleLeft(stolen, n.mutableChild(i+1)))
 	} else if i > 0 {
 
 		//                        n
@@ -274,226 +2590,598 @@ func (n *baseInternalNode[T]) remove(k T) {
 		//     (C              L    P T   X)
 		//     ↓       ↓         ↓
 		// (A B) (✗   E  J K )  (N O)  …
-		n.children[i-1].merge(n.keys.remove(i-1), child)
+		left := n.mutableChild(i - 1)
+		if s.byRank {
+			s.rank += left.size() + 1
+		}
+		left.merge(n.removeAt(i-1), child)
 		n.children.remove(i)
-		child = n.children[i-1]
+		child = left
 	} else if i < len(n.keys) {
-		child.merge(n.keys.remove(i), n.children[i+1])
+		child.merge(n.removeAt(i), n.mutableChild(i+1))
 		n.children.remove(i + 1)
 	}
-	child.remove(k)
+	old, removed = child.remove(s)
+	if removed {
+		n.shrank(old, nil)
+	}
+	return
+}
+
+// separate descends the non-full internal node n towards k, which must be in
+// its subtree, splitting full children on the way down as insertBelowMax does.
+// If k is found in a leaf, the leaf is split about k, moving it up into its
+// parent, provided at least t-1 keys lie either side of k in the leaf.
+func (n *baseInternalNode[T]) separate(k T) {
+	i, found := find(n.keys, k, n.cfg.compare)
+	if found {
+		return
+	}
+	child := n.mutableChild(i)
+	if !child.isBelowMax() {
+		median, newChild := child.split()
+		n.insertAt(i, median)
+		n.children.insert(i+1, newChild)
+		compared := n.cfg.compare(k, n.keys[i])
+		if compared == 0 {
+			return
+		}
+		if compared > 0 {
+			child = newChild
+			i++
+		}
+	}
+	switch child := child.(type) {
+	case *childInternalNode[T]:
+		child.separate(k)
+	case *childLeafNode[T]:
+		j, _ := find(child.keys, k, n.cfg.compare)
+		if j < n.cfg.t-1 || len(child.keys)-j-1 < n.cfg.t-1 {
+			return
+		}
+		median, sibling := child.splitAround(j)
+		n.insertAt(i, median)
+		n.children.insert(i+1, sibling)
+	}
+}
+
+// ascend walks the subtree rooted at the internal node n in order, visiting
+// each child before the key that follows it.
+func (n baseInternalNode[T]) ascend(fn func(T) bool) bool {
+	for i, k := range n.keys {
+		if !n.children[i].ascend(fn) || !fn(k) {
+			return false
+		}
+	}
+	return n.children[len(n.keys)].ascend(fn)
+}
+
+// ascendItems walks the subtree rooted at the internal node n in order like
+// ascend, visiting each key along with its sequence number.
+func (n baseInternalNode[T]) ascendItems(fn func(item[T]) bool) bool {
+	for i := range n.keys {
+		if !n.children[i].ascendItems(fn) || !fn(n.at(i)) {
+			return false
+		}
+	}
+	return n.children[len(n.keys)].ascendItems(fn)
+}
+
+// ascendFrom walks the keys greater than or equal to k in the subtree rooted
+// at the internal node n in order. Children holding only keys less than k are
+// skipped, so that only the child in which k belongs is partially visited.
+func (n baseInternalNode[T]) ascendFrom(k T, fn func(T) bool) bool {
+	i, found := find(n.keys, k, n.cfg.lower)
+	if !found && !n.children[i].ascendFrom(k, fn) {
+		return false
+	}
+	for ; i < len(n.keys); i++ {
+		if !fn(n.keys[i]) || !n.children[i+1].ascend(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// descend walks the subtree rooted at the internal node n in reverse order,
+// visiting each child after the key that follows it.
+func (n baseInternalNode[T]) descend(fn func(T) bool) bool {
+	for i := len(n.keys); i > 0; i-- {
+		if !n.children[i].descend(fn) || !fn(n.keys[i-1]) {
+			return false
+		}
+	}
+	return n.children[0].descend(fn)
+}
+
+// descendFrom walks the keys less than or equal to k in the subtree rooted at
+// the internal node n in reverse order, mirroring ascendFrom.
+func (n baseInternalNode[T]) descendFrom(k T, fn func(T) bool) bool {
+	i, found := find(n.keys, k, n.cfg.upper)
+	if found {
+		if !fn(n.keys[i]) || !n.children[i].descend(fn) {
+			return false
+		}
+	} else if !n.children[i].descendFrom(k, fn) {
+		return false
+	}
+	for ; i > 0; i-- {
+		if !fn(n.keys[i-1]) || !n.children[i-1].descend(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// min returns the first key in the subtree rooted at n, found by following the
+// first child of each node down to a leaf.
+func (n baseInternalNode[T]) min() T {
+	return n.children[0].min()
+}
+
+// max returns the last key in the subtree rooted at n, found by following the
+// last child of each node down to a leaf.
+func (n baseInternalNode[T]) max() T {
+	return n.children[len(n.keys)].max()
+}
+
+// firstLeaf returns the leftmost leaf in the subtree rooted at n.
+func (n baseInternalNode[T]) firstLeaf() *childLeafNode[T] {
+	return n.children[0].firstLeaf()
+}
+
+// lastLeaf returns the rightmost leaf in the subtree rooted at n.
+func (n baseInternalNode[T]) lastLeaf() *childLeafNode[T] {
+	return n.children[len(n.keys)].lastLeaf()
+}
+
+// size returns the number of keys in the subtree rooted at n, as recorded by n
+// if the tree keeps order statistics, or else counted afresh.
+func (n baseInternalNode[T]) size() int {
+	if n.cfg.counted {
+		return n.count
+	}
+	size := len(n.keys)
+	for _, child := range n.children {
+		size += child.size()
+	}
+	return size
+}
+
+// sum returns the total weight of the keys in the subtree rooted at n.
+func (n baseInternalNode[T]) sum() int {
+	return n.total
+}
+
+// reverse reverses the subtree rooted at the internal node n, reversing the
+// keys and children of each node, which all take on cfg.
+func (n *baseInternalNode[T]) reverse(cfg *config[T]) {
+	n.cfg = cfg
+	n.nodeKeys.reverse()
+	n.children.reverse()
+	for _, child := range n.children {
+		child.reverse(cfg)
+	}
+}
+
+// clone returns a copy of the internal node n belonging to the tree configured
+// by cfg, with keys and children of its own. The children themselves are
+// shared with n.
+func (n baseInternalNode[T]) clone(cfg *config[T]) baseInternalNode[T] {
+	c := baseInternalNode[T]{
+		cfg:      cfg,
+		nodeKeys: n.nodeKeys.clone(2*cfg.t - 1),
+		children: newList[childNode[T]](2 * cfg.t),
+		count:    n.count,
+		total:    n.total,
+	}
+	c.children = append(c.children, n.children...)
+	return c
+}
+
+// mutableChild returns the i-th child of n, first replacing it with a copy of
+// its own if it's shared with another tree, so that it may be changed. n
+// itself must already belong to its tree.
+func (n *baseInternalNode[T]) mutableChild(i int) childNode[T] {
+	child := n.children[i].mutable(n.cfg)
+	n.children[i] = child
+	return child
+}
+
+// own makes every node of the subtree rooted at n belong to the tree of n,
+// copying those shared with another tree, ahead of changing the whole subtree.
+func (n *baseInternalNode[T]) own() {
+	for i := range n.children {
+		if child, ok := n.mutableChild(i).(*childInternalNode[T]); ok {
+			child.own()
+		}
+	}
+}
+
+// contents returns the keys and children of the internal node n.
+func (n baseInternalNode[T]) contents() (list[T], list[childNode[T]]) {
+	return n.keys, n.children
+}
+
+// recount recomputes the number of keys in the subtree rooted at n, and their
+// total weight, from the sizes and sums of its children, as needed after
+// moving a number of them at once. The number is left alone unless the tree
+// keeps order statistics, as are those recorded by grew and shrank.
+func (n *baseInternalNode[T]) recount() {
+	n.total = n.weight
+	for _, child := range n.children {
+		n.total += child.sum()
+	}
+	if n.cfg.counted {
+		n.count = len(n.keys)
+		for _, child := range n.children {
+			n.count += child.size()
+		}
+	}
+}
+
+// grew records that k, along with the subtree sub beneath it unless sub is
+// nil, joined the subtree rooted at n.
+func (n *baseInternalNode[T]) grew(k T, sub node[T]) {
+	n.total += n.weightOf(k)
+	if sub != nil {
+		n.total += sub.sum()
+	}
+	if n.cfg.counted {
+		n.count++
+		if sub != nil {
+			n.count += sub.size()
+		}
+	}
+}
+
+// shrank records that k, along with the subtree sub beneath it unless sub is
+// nil, left the subtree rooted at n.
+func (n *baseInternalNode[T]) shrank(k T, sub node[T]) {
+	n.total -= n.weightOf(k)
+	if sub != nil {
+		n.total -= sub.sum()
+	}
+	if n.cfg.counted {
+		n.count--
+		if sub != nil {
+			n.count -= sub.size()
+		}
+	}
+}
+
+// replaced records that k took the place of old in the subtree rooted at n.
+func (n *baseInternalNode[T]) replaced(old, k T) {
+	n.total += n.weightOf(k) - n.weightOf(old)
 }
 
 // childNode represents the functionality of all nodes which are not the root
 // node of the B-tree.
-type childNode[T Comparable[T]] interface {
+type childNode[T any] interface {
 	node[T]
-	asRoot() rootNode[T]            // Reconstructs the node as a rootNode
-	split() (T, childNode[T])       // Splits node the node, creating a sibling
-	merge(T, childNode[T])          // Merges node with a sibling
-	deletePred() T                  // Deletes the last key in the subtree
-	deleteSucc() T                  // Deletes the first key in the subtree
-	shuffleLeft(T, childNode[T]) T  // Shuffles keys around, stealing from the right
-	shuffleRight(T, childNode[T]) T // Shuffles keys around, stealing from the left
+	asRoot() rootNode[T]                        // Reconstructs the node as a rootNode
+	isBelowMin() bool                           // Returns true if the node has too few keys to be a child
+	split() (item[T], childNode[T])             // Splits node the node, creating a sibling
+	merge(item[T], childNode[T])                // Merges node with a sibling
+	deletePred() item[T]                        // Deletes the last key in the subtree
+	deleteSucc() item[T]                        // Deletes the first key in the subtree
+	shuffleLeft(item[T], childNode[T]) item[T]  // Shuffles keys around, stealing from the right
+	shuffleRight(item[T], childNode[T]) item[T] // Shuffles keys around, stealing from the left
+	mutable(*config[T]) childNode[T]            // Returns the node, or a copy if the tree doesn't own it
 }
 
 // childLeafNode implements childNode interface, representing a leaf node which
-// is not the root of the B-tree.
-type childLeafNode[T Comparable[T]] struct {
+// is not the root of the B-tree. When the tree links its leaves, prev and next
+// point to the leaves immediately to the left and right of the node.
+type childLeafNode[T any] struct {
 	baseLeafNode[T]
+	prev, next *childLeafNode[T]
 }
 
-func newChildLeafNode[T Comparable[T]]() *childLeafNode[T] {
-	return &childLeafNode[T]{newBaseLeafNode[T]()}
+func newChildLeafNode[T any](cfg *config[T]) *childLeafNode[T] {
+	if cfg.pool != nil {
+		if n := cfg.pool.leaf(cfg); n != nil {
+			return n
+		}
+	}
+	return &childLeafNode[T]{baseLeafNode: newBaseLeafNode(cfg)}
 }
 func (n childLeafNode[T]) isAboveMin() bool {
-	return len(n.keys) > t-1
+	return len(n.keys) > n.cfg.t-1
 }
 func (n childLeafNode[T]) isBelowMax() bool {
-	return len(n.keys) < 2*t-1
+	return len(n.keys) < 2*n.cfg.t-1
+}
+func (n childLeafNode[T]) isBelowMin() bool {
+	return len(n.keys) < n.cfg.t-1
 }
 func (n childLeafNode[T]) asRoot() rootNode[T] {
 	return &rootLeafNode[T]{n.baseLeafNode}
 }
+func (n *childLeafNode[T]) reverse(cfg *config[T]) {
+	n.baseLeafNode.reverse(cfg)
+	n.prev, n.next = n.next, n.prev
+}
+
+// mutable returns n, or a copy of n if it belongs to another tree. The leaves
+// of a tree linking its leaves are never shared, as such a tree can't be
+// cloned, so a leaf moved over from another such tree is taken over in place
+// instead, keeping its links to the leaves either side.
+func (n *childLeafNode[T]) mutable(cfg *config[T]) childNode[T] {
+	if n.cfg == cfg {
+		return n
+	}
+	if cfg.linkLeaves {
+		n.cfg = cfg
+		return n
+	}
+	return &childLeafNode[T]{baseLeafNode: n.baseLeafNode.clone(cfg)}
+}
+func (n *childLeafNode[T]) firstLeaf() *childLeafNode[T] {
+	return n
+}
+func (n *childLeafNode[T]) lastLeaf() *childLeafNode[T] {
+	return n
+}
 
 // split splits node n in to two, returning the median key and newly created
 // sibling node intended to sperate the nodes in the parent.
-func (n *childLeafNode[T]) split() (T, childNode[T]) {
-	sibling := newChildLeafNode[T]()
-	sibling.keys.splice(0, t, &n.keys)
-	return n.keys.remove(t - 1), sibling
+func (n *childLeafNode[T]) split() (item[T], childNode[T]) {
+	return n.splitAround(n.cfg.t - 1)
+}
+
+// splitAround splits node n about its i-th key, which it returns along with
+// the new sibling node taking the keys after it.
+func (n *childLeafNode[T]) splitAround(i int) (item[T], childNode[T]) {
+	sibling := newChildLeafNode(n.cfg)
+	sibling.spliceAt(0, i+1, &n.nodeKeys)
+	if n.cfg.linkLeaves {
+		sibling.prev, sibling.next = n, n.next
+		if n.next != nil {
+			n.next.prev = sibling
+		}
+		n.next = sibling
+	}
+	return n.removeAt(i), sibling
 }
 
 // merge merges what is intended to be sibling nodes in order around their
 // median key
-func (n *childLeafNode[T]) merge(medianKey T, m childNode[T]) {
+func (n *childLeafNode[T]) merge(median item[T], m childNode[T]) {
 	sibling := m.(*childLeafNode[T])
-	n.keys.insert(len(n.keys), medianKey)
-	n.keys.splice(len(n.keys), 0, &sibling.keys)
+	n.insertAt(len(n.keys), median)
+	n.spliceAt(len(n.keys), 0, &sibling.nodeKeys)
+	if n.cfg.linkLeaves {
+		n.next = sibling.next
+		if sibling.next != nil {
+			sibling.next.prev = n
+		}
+	}
+	if n.cfg.pool != nil && sibling.cfg == n.cfg {
+		n.cfg.pool.putLeaf(sibling)
+	}
 }
 
 // deletePred deletes the sucessor of some key which is the first key of the
 // sub tree rooted at n.
-func (n *childLeafNode[T]) deletePred() T {
-	return n.keys.remove(len(n.keys) - 1)
+func (n *childLeafNode[T]) deletePred() item[T] {
+	return n.removeAt(len(n.keys) - 1)
 }
 
 // deleteSucc deletes the sucessor of some key which is the first key in the
 // sub tree rooted at n.
-func (n *childLeafNode[T]) deleteSucc() T {
-	return n.keys.remove(0)
+func (n *childLeafNode[T]) deleteSucc() item[T] {
+	return n.removeAt(0)
 }
 
-func (n *childLeafNode[T]) shuffleLeft(stolenKey T, m childNode[T]) T {
+func (n *childLeafNode[T]) shuffleLeft(stolen item[T], m childNode[T]) item[T] {
 	sibling := m.(*childLeafNode[T])
-	n.keys.insert(len(n.keys), stolenKey)
-	return sibling.keys.remove(0)
+	n.insertAt(len(n.keys), stolen)
+	return sibling.removeAt(0)
 }
 
-func (n *childLeafNode[T]) shuffleRight(stolenKey T, m childNode[T]) T {
+func (n *childLeafNode[T]) shuffleRight(stolen item[T], m childNode[T]) item[T] {
 	sibling := m.(*childLeafNode[T])
-	n.keys.insert(0, stolenKey)
-	return sibling.keys.remove(len(sibling.keys) - 1)
+	n.insertAt(0, stolen)
+	return sibling.removeAt(len(sibling.keys) - 1)
 }
 
 // childLeafNode implements childNode interface, representing an internal node
 // which is not the root of the B-tree.
-type childInternalNode[T Comparable[T]] struct {
+type childInternalNode[T any] struct {
 	baseInternalNode[T]
 }
 
-func newChildInternalNode[T Comparable[T]]() *childInternalNode[T] {
-	return &childInternalNode[T]{newBaseInternalNode[T]()}
+func newChildInternalNode[T any](cfg *config[T]) *childInternalNode[T] {
+	if cfg.pool != nil {
+		if n := cfg.pool.internal(cfg); n != nil {
+			return n
+		}
+	}
+	return &childInternalNode[T]{newBaseInternalNode(cfg)}
 }
 
 func (n childInternalNode[T]) isAboveMin() bool {
-	return len(n.keys) > t-1
+	return len(n.keys) > n.cfg.t-1
 }
 func (n childInternalNode[T]) isBelowMax() bool {
-	return len(n.keys) < 2*t-1
+	return len(n.keys) < 2*n.cfg.t-1
+}
+func (n childInternalNode[T]) isBelowMin() bool {
+	return len(n.keys) < n.cfg.t-1
 }
 func (n childInternalNode[T]) asRoot() rootNode[T] {
 	return &rootInternalNode[T]{n.baseInternalNode}
 }
+func (n *childInternalNode[T]) mutable(cfg *config[T]) childNode[T] {
+	if n.cfg == cfg {
+		return n
+	}
+	return &childInternalNode[T]{n.baseInternalNode.clone(cfg)}
+}
 
 // split splits node n in to two, returning the median key and newly created
 // sibling node intended to sperate the nodes in the parent.
-func (n *childInternalNode[T]) split() (T, childNode[T]) {
-	sibling := newChildInternalNode[T]()
-	sibling.children.splice(0, t, &n.children)
-	sibling.keys.splice(0, t, &n.keys)
-	return n.keys.remove(t - 1), sibling
+func (n *childInternalNode[T]) split() (item[T], childNode[T]) {
+	sibling := newChildInternalNode(n.cfg)
+	sibling.children.splice(0, n.cfg.t, &n.children)
+	sibling.spliceAt(0, n.cfg.t, &n.nodeKeys)
+	sibling.recount()
+	median := n.removeAt(n.cfg.t - 1)
+	n.shrank(median.key, sibling)
+	return median, sibling
 }
 
 // merge merges what is intended to be sibling nodes in order around their
 // median key.
-func (n *childInternalNode[T]) merge(medianKey T, m childNode[T]) {
+func (n *childInternalNode[T]) merge(median item[T], m childNode[T]) {
 	sibling := m.(*childInternalNode[T])
-	n.keys.insert(len(n.keys), medianKey)
-	n.keys.splice(len(n.keys), 0, &sibling.keys)
+	n.insertAt(len(n.keys), median)
+	n.spliceAt(len(n.keys), 0, &sibling.nodeKeys)
 	n.children.splice(len(n.children), 0, &sibling.children)
+	n.grew(median.key, sibling)
+	if n.cfg.pool != nil && sibling.cfg == n.cfg {
+		n.cfg.pool.putInternal(sibling)
+	}
 }
 
-// deletePred deletes the sucessor of some key key which is the first key
-// of the sub tree rooted at n.
-func (n childInternalNode[T]) deletePred() T {
+// deletePred deletes the last key in the sub tree rooted at n, the predecessor
+// of the key of the parent of n which follows n. Like remove, it descends in a
+// single pass, filling out the last child of each node on the way down should
+// it hold the fewest keys allowed.
+func (n *childInternalNode[T]) deletePred() item[T] {
 	var (
-		i     = 0
-		child = n.children[i]
+		i     = len(n.keys)
+		child = n.mutableChild(i)
 	)
-	if child.isAboveMin() {
-		return child.deletePred()
-	}
-
-	right := n.children[i+1]
-	if right.isAboveMin() {
-		key := n.keys.remove(i + 1)
-		n.keys.insert(i+1, child.shuffleLeft(key, right))
-		return child.deletePred()
+	if !child.isAboveMin() {
+		left := n.mutableChild(i - 1)
+		if left.isAboveMin() {
+			n.set(i-1, child.shuffleRight(n.at(i-1), left))
+		} else {
+			left.merge(n.removeAt(i-1), child)
+			n.children.remove(i)
+			child = left
+		}
 	}
-	n.children.remove(i + 1)
-	child.merge(n.keys.remove(i), right)
-	return child.deletePred()
+	pred := child.deletePred()
+	n.shrank(pred.key, nil)
+	return pred
 }
 
-// deleteSucc deletes the sucessor of some key key which is the first key
-// in the sub tree rooted at n.
-func (n childInternalNode[T]) deleteSucc() T {
+// deleteSucc deletes the first key in the sub tree rooted at n, the successor
+// of the key of the parent of n which precedes n, filling out the first child
+// of each node on the way down in the same way.
+func (n *childInternalNode[T]) deleteSucc() item[T] {
 	var (
-		i     = len(n.keys)
-		child = n.children[i]
+		i     = 0
+		child = n.mutableChild(i)
 	)
-	if child.isAboveMin() {
-		return child.deleteSucc()
-	}
-
-	left := n.children[i-1]
-	if left.isAboveMin() {
-		key := n.keys.remove(i - 1)
-		n.keys.insert(i-1, child.shuffleRight(key, left))
-		return child.deleteSucc()
+	if !child.isAboveMin() {
+		right := n.mutableChild(i + 1)
+		if right.isAboveMin() {
+			n.set(i, child.shuffleLeft(n.at(i), right))
+		} else {
+			child.merge(n.removeAt(i), right)
+			n.children.remove(i + 1)
+		}
 	}
-	left.merge(n.keys.remove(i-1), child)
-	return left.deleteSucc()
+	succ := child.deleteSucc()
+	n.shrank(succ.key, nil)
+	return succ
 }
 
-func (n *childInternalNode[T]) shuffleLeft(stolenKey T, m childNode[T]) T {
-	sibling := m.(*childInternalNode[T])
-	n.keys.insert(len(n.keys), stolenKey)
-	n.children.insert(len(n.children), sibling.children.remove(0))
-	return sibling.keys.remove(0)
+// shuffleLeft moves stolen, the key of the parent between n and its right
+// sibling m, to the end of n, along with the first child of m, returning the
+// first key of m to take the place of stolen in the parent. m must hold at
+// least one key, as it does whenever it can spare one.
+func (n *childInternalNode[T]) shuffleLeft(stolen item[T], m childNode[T]) item[T] {
+	var (
+		sibling = m.(*childInternalNode[T])
+		child   = sibling.children.remove(0)
+	)
+	n.insertAt(len(n.keys), stolen)
+	n.children.insert(len(n.children), child)
+	n.grew(stolen.key, child)
+	first := sibling.removeAt(0)
+	sibling.shrank(first.key, child)
+	return first
 }
 
-func (n *childInternalNode[T]) shuffleRight(stolenKey T, m childNode[T]) T {
-	sibling := m.(*childInternalNode[T])
-	n.keys.insert(0, stolenKey)
-	n.children.insert(0, sibling.children.remove(len(sibling.keys)))
-	return sibling.keys.remove(len(sibling.keys) - 1)
+// shuffleRight moves stolen, the key of the parent between n and its left
+// sibling m, to the start of n, along with the last child of m, returning the
+// last key of m to take the place of stolen in the parent. The last child of m
+// lies at the index of its number of keys, which is found before any of them
+// is removed. As for shuffleLeft, m must hold at least one key.
+func (n *childInternalNode[T]) shuffleRight(stolen item[T], m childNode[T]) item[T] {
+	var (
+		sibling = m.(*childInternalNode[T])
+		child   = sibling.children.remove(len(sibling.keys))
+	)
+	n.insertAt(0, stolen)
+	n.children.insert(0, child)
+	n.grew(stolen.key, child)
+	last := sibling.removeAt(len(sibling.keys) - 1)
+	sibling.shrank(last.key, child)
+	return last
 }
 
 // rootNode represents the functionality of the root node of the tree
-type rootNode[T Comparable[T]] interface {
+type rootNode[T any] interface {
 	node[T]
-	shrink() rootNode[T]   // Shrinks the subtree when root node is empty
-	asChild() childNode[T] // Reconstructs the root node as a child node
+	shrink() rootNode[T]            // Shrinks the subtree when root node is empty
+	asChild() childNode[T]          // Reconstructs the root node as a child node
+	mutable(*config[T]) rootNode[T] // Returns the node, or a copy if the tree doesn't own it
 }
 
 // rootLeafNode implements rootNode interface, representing a leaf node which
 // is the root of the B-tree.
-type rootLeafNode[T Comparable[T]] struct {
+type rootLeafNode[T any] struct {
 	baseLeafNode[T]
 }
 
-func newRootLeafNode[T Comparable[T]]() *rootLeafNode[T] {
-	return &rootLeafNode[T]{newBaseLeafNode[T]()}
+func newRootLeafNode[T any](cfg *config[T]) *rootLeafNode[T] {
+	return &rootLeafNode[T]{newBaseLeafNode(cfg)}
 }
 func (n rootLeafNode[T]) isAboveMin() bool {
 	return len(n.keys) > 0
 }
 func (n rootLeafNode[T]) isBelowMax() bool {
-	return len(n.keys) < 2*t-1
+	return len(n.keys) < 2*n.cfg.t-1
 }
 func (n rootLeafNode[T]) shrink() rootNode[T] {
 	return &n
 }
 func (n rootLeafNode[T]) asChild() childNode[T] {
-	return &childLeafNode[T]{n.baseLeafNode}
+	return &childLeafNode[T]{baseLeafNode: n.baseLeafNode}
+}
+func (n *rootLeafNode[T]) mutable(cfg *config[T]) rootNode[T] {
+	if n.cfg == cfg {
+		return n
+	}
+	return &rootLeafNode[T]{n.baseLeafNode.clone(cfg)}
+}
+func (n rootLeafNode[T]) firstLeaf() *childLeafNode[T] {
+	return nil
+}
+func (n rootLeafNode[T]) lastLeaf() *childLeafNode[T] {
+	return nil
 }
 
 // rootInternalNode implements rootNode interface, representing an internal
 // node which is root of the B-tree.
-type rootInternalNode[T Comparable[T]] struct {
+type rootInternalNode[T any] struct {
 	baseInternalNode[T]
 }
 
-func newRootInternalNode[T Comparable[T]]() *rootInternalNode[T] {
-	return &rootInternalNode[T]{newBaseInternalNode[T]()}
+func newRootInternalNode[T any](cfg *config[T]) *rootInternalNode[T] {
+	return &rootInternalNode[T]{newBaseInternalNode(cfg)}
 }
 func (n rootInternalNode[T]) isAboveMin() bool {
 	return len(n.keys) > 0
 }
 func (n rootInternalNode[T]) isBelowMax() bool {
-	return len(n.keys) < 2*t-1
+	return len(n.keys) < 2*n.cfg.t-1
 }
 func (n rootInternalNode[T]) shrink() rootNode[T] {
 	return n.children[0].asRoot()
@@ -501,3 +3189,9 @@ func (n rootInternalNode[T]) shrink() rootNode[T] {
 func (n rootInternalNode[T]) asChild() childNode[T] {
 	return &childInternalNode[T]{n.baseInternalNode}
 }
+func (n *rootInternalNode[T]) mutable(cfg *config[T]) rootNode[T] {
+	if n.cfg == cfg {
+		return n
+	}
+	return &rootInternalNode[T]{n.baseInternalNode.clone(cfg)}
+}
@@ -0,0 +1,1538 @@
+package btree
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+// key is an integer key for the tests, ordered as integers are.
+type key int
+
+func (a key) Compare(b key) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// ascending returns the keys of b in ascending order.
+func ascending[T any](b *BTree[T]) []T {
+	var keys []T
+	b.Ascend(func(k T) bool {
+		keys = append(keys, k)
+		return true
+	})
+	return keys
+}
+
+// mustValidate fails the test if b breaks any invariant of a B-Tree.
+func mustValidate[T any](t *testing.T, b *BTree[T]) {
+	t.Helper()
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSelectByWeight(t *testing.T) {
+	weight := func(k key) int { return int(k) % 5 }
+	for _, weighed := range []bool{true, false} {
+		var (
+			b = NewBTreeWithOptions(Options[key]{Degree: 3})
+			w = weight
+			r = rand.New(rand.NewSource(1))
+		)
+		if weighed {
+			b, w = NewBTreeWithOptions(Options[key]{Degree: 3, Weight: weight}), nil
+		}
+		for round := 0; round < 20; round++ {
+			for i := 0; i < 200; i++ {
+				b.Insert(key(r.Intn(1000)))
+			}
+			for i := 0; i < 100; i++ {
+				b.Remove(key(r.Intn(1000)))
+			}
+			b.UpdateValue(key(r.Intn(1000)), func(old key) key { return old })
+			mustValidate(t, b)
+
+			keys := ascending(b)
+			total := 0
+			for _, k := range keys {
+				total += weight(k)
+			}
+			for target := 0; target <= total+1; target += 1 + r.Intn(7) {
+				want, wantFound, sum := key(0), false, 0
+				for _, k := range keys {
+					if sum += weight(k); sum >= target {
+						want, wantFound = k, true
+						break
+					}
+				}
+				got, found := b.SelectByWeight(target, w)
+				if found != wantFound || got != want {
+					t.Fatalf("SelectByWeight(%d) of a tree weighed %t = %v, %v, want %v, %v",
+						target, weighed, got, found, want, wantFound)
+				}
+			}
+		}
+	}
+	if _, found := NewBTree[key]().SelectByWeight(0, weight); found {
+		t.Fatal("SelectByWeight found a key in an empty tree")
+	}
+}
+
+func TestSelectByWeightWithoutWeight(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("SelectByWeight didn't panic without a weight on a tree without a Weight")
+		}
+	}()
+	NewBTree[key]().SelectByWeight(1, nil)
+}
+
+func TestScanLeaves(t *testing.T) {
+	for _, opts := range []Options[key]{
+		{Degree: 3},
+		{Degree: 3, LinkLeaves: true},
+		{Degree: 3, LinkLeaves: true, Multiset: true},
+	} {
+		b := NewBTreeWithOptions(opts)
+		r := rand.New(rand.NewSource(2))
+		for i := 0; i < 2000; i++ {
+			b.Insert(key(r.Intn(300)))
+		}
+		want := ascending(b)
+		var got []key
+		b.ScanLeaves(func(k key) bool {
+			got = append(got, k)
+			return true
+		})
+		if !equal(got, want) {
+			t.Fatalf("ScanLeaves with %+v visited %v, want %v", opts, got, want)
+		}
+
+		got = got[:0]
+		b.ScanLeaves(func(k key) bool {
+			got = append(got, k)
+			return len(got) < 100
+		})
+		if !equal(got, want[:100]) {
+			t.Fatalf("ScanLeaves with %+v stopped after %v, want %v", opts, got, want[:100])
+		}
+	}
+}
+
+// equal reports whether a and b hold the same keys in the same order.
+func equal[T comparable](a, b []T) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// benchmarkWalk times walk over a tree of 200,000 keys of degree 32 which
+// links its leaves.
+func benchmarkWalk(b *testing.B, walk func(*BTree[key], func(key) bool)) {
+	tree := NewBTreeWithOptions(Options[key]{Degree: 32, LinkLeaves: true})
+	for i := 0; i < 200000; i++ {
+		tree.Insert(key(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		walk(tree, func(key) bool { return true })
+	}
+}
+
+func BenchmarkScanLeaves(b *testing.B) {
+	benchmarkWalk(b, (*BTree[key]).ScanLeaves)
+}
+
+func BenchmarkAscend(b *testing.B) {
+	benchmarkWalk(b, (*BTree[key]).Ascend)
+}
+
+func TestInsertWithHint(t *testing.T) {
+	a := NewBTreeWithOptions(Options[key]{Degree: 3})
+	hint := a.Seek(0)
+	for i := 0; i < 500; i += 2 {
+		a.InsertWithHint(key(i), hint)
+		if k, ok := hint.Value(); !ok || k != key(i) {
+			t.Fatalf("hint at %v, %v after inserting %v", k, ok, i)
+		}
+	}
+	b := a.Clone()
+
+	// The hint left in a is stale for b, and the two trees take turns with
+	// hints of their own, neither of which may lead the other astray.
+	other := b.Seek(0)
+	for i := 1; i < 500; i += 2 {
+		b.InsertWithHint(key(i), hint)
+		a.InsertWithHint(key(500+i), other)
+		b.InsertWithHint(key(1000+i), other)
+	}
+	mustValidate(t, a)
+	mustValidate(t, b)
+	if got, want := a.Len(), 500; got != want {
+		t.Fatalf("a has %d keys, want %d", got, want)
+	}
+	if got, want := b.Len(), 750; got != want {
+		t.Fatalf("b has %d keys, want %d", got, want)
+	}
+	for i := 0; i < 500; i++ {
+		if _, found := b.Search(key(i)); !found {
+			t.Fatalf("b lacks %d", i)
+		}
+	}
+}
+
+// benchmarkInsertAscending times the insertion of 100,000 ascending keys into
+// an empty tree, by insert.
+func benchmarkInsertAscending(b *testing.B, insert func(*BTree[key], key)) {
+	for i := 0; i < b.N; i++ {
+		tree := NewBTree[key]()
+		for j := 0; j < 100000; j++ {
+			insert(tree, key(j))
+		}
+	}
+}
+
+func BenchmarkInsertAscending(b *testing.B) {
+	benchmarkInsertAscending(b, (*BTree[key]).Insert)
+}
+
+func BenchmarkInsertWithHintAscending(b *testing.B) {
+	var hint *Cursor[key]
+	benchmarkInsertAscending(b, func(tree *BTree[key], k key) {
+		if k == 0 {
+			hint = tree.Seek(k)
+		}
+		tree.InsertWithHint(k, hint)
+	})
+}
+
+// tagged is a key along with a tag telling apart keys comparing equal, which
+// is ignored by Compare.
+type tagged struct {
+	key key
+	tag int
+}
+
+func (a tagged) Compare(b tagged) int {
+	return a.key.Compare(b.key)
+}
+
+// churn applies a random sequence of operations, determined by seed, to the
+// multiset b, tagging each key inserted with the number of keys inserted
+// before it.
+func churn(b *BTree[tagged], seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < 3000; i++ {
+		switch op := r.Intn(10); {
+		case op < 7:
+			b.Insert(tagged{key(r.Intn(50)), i})
+		case op < 9:
+			b.Remove(tagged{key: key(r.Intn(50))})
+		case i%500 == 0:
+			b.Reverse()
+		}
+	}
+}
+
+func TestAscendOrderIsDeterministic(t *testing.T) {
+	for seed := int64(0); seed < 5; seed++ {
+		a := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+		b := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+		churn(a, seed)
+		churn(b, seed)
+		mustValidate(t, a)
+		if got, want := ascending(a), ascending(b); !equal(got, want) {
+			t.Fatalf("identical operations walked as %v and %v", got, want)
+		}
+	}
+}
+
+func TestAscendWalksEqualKeysInOrderOfInsertion(t *testing.T) {
+	b := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+	r := rand.New(rand.NewSource(3))
+	tag := 0
+	insert := func(n int) {
+		for ; n > 0; n-- {
+			b.Insert(tagged{key(r.Intn(20)), tag})
+			tag++
+		}
+	}
+	// inOrder checks that each run of equal keys is walked with ascending
+	// tags, or descending ones if newest is set.
+	inOrder := func(newest bool) {
+		t.Helper()
+		keys := ascending(b)
+		for i := 1; i < len(keys); i++ {
+			prev, k := keys[i-1], keys[i]
+			if prev.key == k.key && (prev.tag < k.tag) == newest {
+				t.Fatalf("keys %v and %v are walked out of the order of insertion", prev, k)
+			}
+		}
+	}
+
+	insert(1000)
+	inOrder(false)
+	b.Reverse()
+	inOrder(true)
+	insert(1000)
+	inOrder(true)
+	b.Reverse()
+	inOrder(false)
+	insert(1000)
+	inOrder(false)
+	mustValidate(t, b)
+}
+
+func TestEstimateComparisons(t *testing.T) {
+	compared := 0
+	b := NewBTreeFunc(func(a, c int) int {
+		compared++
+		return a - c
+	})
+	if n := b.EstimateComparisons([]int{1, 2, 3}); n != 0 {
+		t.Fatalf("EstimateComparisons of an empty tree = %d, want 0", n)
+	}
+	for i := 0; i < 10000; i++ {
+		b.Insert(i * 2)
+	}
+	queries := []int{-1, 0, 1, 5000, 9999, 10000, 19998, 20001}
+
+	compared = 0
+	for _, q := range queries {
+		b.Search(q)
+	}
+	want := compared
+	compared = 0
+	if n := b.EstimateComparisons(queries); n != want || compared != want {
+		t.Fatalf("EstimateComparisons = %d after %d comparisons, want %d", n, compared, want)
+	}
+}
+
+func TestRemoveAt(t *testing.T) {
+	for _, multiset := range []bool{false, true} {
+		var (
+			b      = NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: multiset})
+			r      = rand.New(rand.NewSource(6))
+			hooked []tagged
+		)
+		b.BeforeRemove = func(k tagged) { hooked = append(hooked, k) }
+		for i := 0; i < 3000; i++ {
+			b.Insert(tagged{key(r.Intn(100)), i})
+		}
+		for _, i := range []int{-1, b.Len()} {
+			if k, removed := b.RemoveAt(i); removed {
+				t.Fatalf("RemoveAt(%d) removed %v from a tree of %d keys", i, k, b.Len())
+			}
+		}
+		for b.Len() > 0 {
+			keys := ascending(b)
+			i := r.Intn(len(keys))
+			k, removed := b.RemoveAt(i)
+			if !removed || k != keys[i] {
+				t.Fatalf("RemoveAt(%d) = %v, %v, want %v", i, k, removed, keys[i])
+			}
+			if hooked[len(hooked)-1] != k {
+				t.Fatalf("BeforeRemove was called with %v, want %v", hooked[len(hooked)-1], k)
+			}
+			want := append(keys[:i:i], keys[i+1:]...)
+			if got := ascending(b); !equal(got, want) {
+				t.Fatalf("RemoveAt(%d) left %v, want %v", i, got, want)
+			}
+			mustValidate(t, b)
+		}
+	}
+}
+
+func TestRemoveAtShuffles(t *testing.T) {
+	var (
+		b     = NewBTreeWithOptions(Options[key]{Degree: 3, OrderStatistics: true})
+		r     = rand.New(rand.NewSource(7))
+		drawn = make(map[key]bool)
+	)
+	for i := 0; i < 2000; i++ {
+		b.Insert(key(i))
+	}
+	for n := b.Len(); n > 0; n-- {
+		k, removed := b.RemoveAt(r.Intn(n))
+		if !removed || drawn[k] || k < 0 || k >= 2000 {
+			t.Fatalf("RemoveAt drew %v, %v with %d keys left", k, removed, n)
+		}
+		drawn[k] = true
+		if b.Len() != n-1 {
+			t.Fatalf("Len = %d after a draw from %d keys", b.Len(), n)
+		}
+		if n%100 == 0 {
+			mustValidate(t, b)
+		}
+	}
+	if _, removed := b.RemoveAt(0); removed {
+		t.Fatal("RemoveAt(0) removed a key from an empty tree")
+	}
+	mustValidate(t, b)
+}
+
+// less returns the number of keys which are less than k.
+func less(keys []key, k key) int {
+	n := 0
+	for _, held := range keys {
+		if held < k {
+			n++
+		}
+	}
+	return n
+}
+
+func TestIndexRange(t *testing.T) {
+	for _, multiset := range []bool{false, true} {
+		b := NewBTreeWithOptions(Options[key]{Degree: 3, Multiset: multiset})
+		r := rand.New(rand.NewSource(7))
+		for i := 0; i < 2000; i++ {
+			b.Insert(key(r.Intn(200)))
+		}
+		keys := ascending(b)
+		for from := key(-1); from <= 201; from++ {
+			for _, to := range []key{from - 1, from, from + 1, from + 10} {
+				lo, hi := b.IndexRange(from, to)
+				wantLo, wantHi := less(keys, from), max(less(keys, to), less(keys, from))
+				if lo != wantLo || hi != wantHi {
+					t.Fatalf("IndexRange(%d, %d) = %d, %d, want %d, %d", from, to, lo, hi, wantLo, wantHi)
+				}
+			}
+		}
+	}
+}
+
+func TestEstimateCountRange(t *testing.T) {
+	for _, multiset := range []bool{false, true} {
+		b := NewBTreeWithOptions(Options[key]{Degree: 3, Multiset: multiset})
+		r := rand.New(rand.NewSource(8))
+		for i := 0; i < 12; i++ {
+			b.Insert(key(r.Intn(4)))
+		}
+		if b.Height() > 2 {
+			t.Fatalf("tree of %d keys is %d levels high", b.Len(), b.Height())
+		}
+
+		// Trees of up to two levels are counted exactly.
+		keys := ascending(b)
+		for from := key(-1); from <= 5; from++ {
+			for to := from; to <= 5; to++ {
+				want := less(keys, to) - less(keys, from)
+				if got := b.EstimateCountRange(from, to); got != want {
+					t.Fatalf("EstimateCountRange(%d, %d) = %d, want %d", from, to, got, want)
+				}
+			}
+		}
+
+		// Deeper trees are off by at most the largest subtree beneath the top
+		// two levels at either end.
+		for i := 0; i < 5000; i++ {
+			b.Insert(key(r.Intn(100)))
+		}
+		keys = ascending(b)
+		largest := 0
+		_, children := b.root.contents()
+		for _, child := range children {
+			_, below := child.contents()
+			for _, grandchild := range below {
+				largest = max(largest, grandchild.size())
+			}
+		}
+		for from := key(-1); from <= 101; from += 7 {
+			to := from + 30
+			want := less(keys, to) - less(keys, from)
+			if got := b.EstimateCountRange(from, to); got < want-2*largest || got > want+2*largest {
+				t.Fatalf("EstimateCountRange(%d, %d) = %d, far from %d", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	b := NewBTree[key]()
+	for i := 0; i < 50; i++ {
+		b.Insert(key(i))
+	}
+	got := b.Histogram(func(k key) int { return int(k)/10 - 1 }, 3)
+	if want := []int{10, 10, 10}; !equal(got, want) {
+		t.Fatalf("Histogram = %v, want %v, dropping keys either side of the buckets", got, want)
+	}
+	got = b.Histogram(func(k key) int { return int(k) - 100 }, 3)
+	if want := []int{0, 0, 0}; !equal(got, want) {
+		t.Fatalf("Histogram = %v, want %v, dropping every key", got, want)
+	}
+	for _, numBuckets := range []int{0, -1} {
+		if got := b.Histogram(func(key) int { return 0 }, numBuckets); got != nil {
+			t.Fatalf("Histogram with %d buckets = %v, want nil", numBuckets, got)
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	b := filled(Options[key]{Degree: 3}, 1000)
+	if b.Height() < 4 {
+		t.Fatalf("tree of %d keys is only %d levels high", b.Len(), b.Height())
+	}
+	var got []key
+	for k := range b.All() {
+		got = append(got, k)
+	}
+	if want := span(0, 1000); !equal(got, want) {
+		t.Fatalf("All yielded %v, want %v", got, want)
+	}
+
+	got = got[:0]
+	for k := range b.All() {
+		if k == 500 {
+			break
+		}
+		got = append(got, k)
+	}
+	if want := span(0, 500); !equal(got, want) {
+		t.Fatalf("All yielded %v before breaking, want %v", got, want)
+	}
+}
+
+func TestPopMinAndPopMax(t *testing.T) {
+	b := filled(Options[key]{Degree: 3}, 1000)
+	lo, hi := key(0), key(999)
+	for i := 0; b.Len() > 0; i++ {
+		var (
+			k      key
+			popped bool
+			want   key
+		)
+		if i%3 == 0 {
+			k, popped = b.PopMax()
+			want, hi = hi, hi-1
+		} else {
+			k, popped = b.PopMin()
+			want, lo = lo, lo+1
+		}
+		if !popped || k != want {
+			t.Fatalf("pop %d = %v, %v, want %v", i, k, popped, want)
+		}
+		if b.Len() != int(hi-lo+1) {
+			t.Fatalf("tree records %d keys after pop %d, want %d", b.Len(), i, hi-lo+1)
+		}
+		mustValidate(t, b)
+	}
+	if b.Height() != 0 {
+		t.Fatalf("emptied tree is %d levels high", b.Height())
+	}
+	if k, popped := b.PopMin(); popped {
+		t.Fatalf("PopMin of an empty tree = %v", k)
+	}
+	if k, popped := b.PopMax(); popped {
+		t.Fatalf("PopMax of an empty tree = %v", k)
+	}
+}
+
+// upperKeys returns the keys of b held by internal nodes whose children are
+// themselves internal, the removal of which descends through the internal
+// nodes beneath them by deletePred or deleteSucc.
+func upperKeys(b *BTree[key]) []key {
+	var (
+		keys []key
+		walk func(n node[key])
+	)
+	walk = func(n node[key]) {
+		held, children := n.contents()
+		if children == nil {
+			return
+		}
+		if _, below := children[0].contents(); below != nil {
+			keys = append(keys, held...)
+		}
+		for _, child := range children {
+			walk(child)
+		}
+	}
+	walk(b.root)
+	return keys
+}
+
+// TestRemoveFromUpperInternalNodes removes keys from the upper levels of a
+// deep tree until none are left there, so that removals take the key either
+// side of them from internal children, at times at the fewest keys allowed,
+// which are filled out or merged along the way down. Such removals once
+// corrupted the tree while deletePred and deleteSucc had value receivers.
+func TestRemoveFromUpperInternalNodes(t *testing.T) {
+	b := filled(Options[key]{Degree: 3}, 2000)
+	held := map[key]bool{}
+	for _, k := range ascending(b) {
+		held[k] = true
+	}
+	r := rand.New(rand.NewSource(10))
+	for keys := upperKeys(b); len(keys) > 0; keys = upperKeys(b) {
+		k := keys[r.Intn(len(keys))]
+		b.Remove(k)
+		delete(held, k)
+		mustValidate(t, b)
+		if _, found := b.Search(k); found {
+			t.Fatalf("%v is still found once removed", k)
+		}
+	}
+	if b.Len() != len(held) {
+		t.Fatalf("tree records %d keys, want %d", b.Len(), len(held))
+	}
+	for k := range held {
+		if _, found := b.Search(k); !found {
+			t.Fatalf("%v is missing", k)
+		}
+	}
+}
+
+func TestFromSortedSlice(t *testing.T) {
+	for _, n := range []int{0, 1, 2, 2*defaultDegree - 1, 2 * defaultDegree, 5000, 30000} {
+		var (
+			keys  = span(0, n)
+			built = FromSortedSlice(keys)
+			naive = NewBTree[key]()
+		)
+		for _, k := range keys {
+			naive.Insert(k)
+		}
+		mustHold(t, built, ascending(naive))
+		if built.Height() > naive.Height() {
+			t.Fatalf("tree built from %d keys is %d levels high, taller than the %d of inserting them", n, built.Height(), naive.Height())
+		}
+
+		// The built tree goes on to change like any other.
+		built.Insert(key(n))
+		built.Remove(key(n / 2))
+		naive.Insert(key(n))
+		naive.Remove(key(n / 2))
+		mustHold(t, built, ascending(naive))
+	}
+}
+
+func TestRange(t *testing.T) {
+	compared := 0
+	b := NewBTreeFunc(func(a, c int) int {
+		compared++
+		return a - c
+	})
+	for _, i := range rand.New(rand.NewSource(11)).Perm(5000) {
+		b.Insert(i)
+	}
+	collect := func(lo, hi int) []int {
+		var keys []int
+		for k := range b.Range(lo, hi) {
+			keys = append(keys, k)
+		}
+		return keys
+	}
+	for _, bounds := range [][2]int{{-10, -1}, {-10, 0}, {100, 199}, {4990, 6000}, {7, 7}, {50, 40}} {
+		lo, hi := bounds[0], bounds[1]
+		var want []int
+		for k := max(lo, 0); k <= min(hi, 4999); k++ {
+			want = append(want, k)
+		}
+		compared = 0
+		if got := collect(lo, hi); !equal(got, want) {
+			t.Fatalf("Range(%d, %d) yielded %v, want %v", lo, hi, got, want)
+		}
+
+		// Only the keys in the range, and those on the paths to its ends,
+		// are compared.
+		if limit := 2*len(want) + 100; compared > limit {
+			t.Fatalf("Range(%d, %d) made %d comparisons for %d keys", lo, hi, compared, len(want))
+		}
+	}
+
+	var got []int
+	for k := range b.Range(100, 199) {
+		if k == 150 {
+			break
+		}
+		got = append(got, k)
+	}
+	if len(got) != 50 || got[49] != 149 {
+		t.Fatalf("Range yielded %v before breaking", got)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	for _, opts := range []Options[key]{{Degree: 3}, {Degree: 3, LinkLeaves: true}} {
+		var (
+			b   = filled(opts, 1000)
+			dup = b.Copy()
+		)
+		mustHold(t, dup, span(0, 1000))
+
+		// Each tree changes in its own way, unseen by the other.
+		for i := 0; i < 1000; i += 2 {
+			b.Remove(key(i))
+			dup.Insert(key(1000 + i))
+		}
+		for i := 1; i < 1000; i += 4 {
+			dup.Remove(key(i))
+		}
+		var want []key
+		for i := 1; i < 1000; i += 2 {
+			want = append(want, key(i))
+		}
+		mustHold(t, b, want)
+		want = want[:0]
+		for i := 0; i < 2000; i++ {
+			if i >= 1000 && i%2 == 0 || i < 1000 && i%4 != 1 {
+				want = append(want, key(i))
+			}
+		}
+		mustHold(t, dup, want)
+	}
+}
+
+func TestMultisetProbesFindTheFirstMatch(t *testing.T) {
+	b := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+	for i := 0; i < 2000; i++ {
+		b.Insert(tagged{key(i % 40), i})
+	}
+	// check probes for each key, expecting the first of the keys matching it
+	// in ascending order, with the keys either side of that one.
+	check := func() {
+		t.Helper()
+		keys := ascending(b)
+		for i, k := range keys {
+			if i > 0 && keys[i-1].key == k.key {
+				continue
+			}
+			probe := tagged{key: k.key}
+			if got, _ := b.Search(probe); got != k {
+				t.Fatalf("Search found %v, want %v", got, k)
+			}
+			if got, _, _ := b.Locate(probe); got != k {
+				t.Fatalf("Locate found %v, want %v", got, k)
+			}
+			if got, _ := b.Floor(probe); got != k {
+				t.Fatalf("Floor found %v, want %v", got, k)
+			}
+			if got, _ := b.Ceiling(probe); got != k {
+				t.Fatalf("Ceiling found %v, want %v", got, k)
+			}
+			prev, match, next, _ := b.SearchContext(probe)
+			if match != k || i > 0 && prev != keys[i-1] || next != keys[i+1] {
+				t.Fatalf("SearchContext found %v, %v, %v around the %d-th key of %v", prev, match, next, i, keys)
+			}
+		}
+	}
+
+	check()
+	b.Reverse()
+	check()
+	b.Reverse()
+
+	keys := ascending(b)
+	b.UpdateValue(tagged{key: 7}, func(old tagged) tagged {
+		return tagged{old.key, -old.tag}
+	})
+	keys[7*50].tag = -keys[7*50].tag
+	if got := ascending(b); !equal(got, keys) {
+		t.Fatalf("UpdateValue left %v, want %v", got, keys)
+	}
+	b.Remove(tagged{key: 7})
+	keys = append(keys[:7*50], keys[7*50+1:]...)
+	if got := ascending(b); !equal(got, keys) {
+		t.Fatalf("Remove left %v, want %v", got, keys)
+	}
+	mustValidate(t, b)
+}
+
+func TestMultisetRemoveIfRemovesTheKeysChosen(t *testing.T) {
+	b := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+	for i := 0; i < 2000; i++ {
+		b.Insert(tagged{key(i % 40), i})
+	}
+	var want []tagged
+	for _, k := range ascending(b) {
+		if k.tag%7 != 0 && (k.key < 10 || k.key >= 20 || k.tag%3 != 0) {
+			want = append(want, k)
+		}
+	}
+	b.RemoveIf(func(k tagged) bool { return k.tag%7 == 0 })
+	b.RemoveRangeIf(tagged{key: 10}, tagged{key: 20}, func(k tagged) bool { return k.tag%3 == 0 })
+	mustValidate(t, b)
+	if got := ascending(b); !equal(got, want) {
+		t.Fatalf("tree holds %v, want %v", got, want)
+	}
+}
+
+func TestRankAndSelect(t *testing.T) {
+	for _, opts := range []Options[key]{{Degree: 3}, {Degree: 3, OrderStatistics: true}, {Degree: 3, Multiset: true}} {
+		var (
+			b = NewBTreeWithOptions(opts)
+			r = rand.New(rand.NewSource(5))
+		)
+		for round := 0; round < 10; round++ {
+			for i := 0; i < 300; i++ {
+				b.Insert(key(r.Intn(500)))
+			}
+			for i := 0; i < 100; i++ {
+				b.Remove(key(r.Intn(500)))
+			}
+			keys := b.ToSlice()
+			for i, k := range keys {
+				if got, ok := b.Select(i); !ok || got != k {
+					t.Fatalf("Select(%d) = %v, %t, want %v", i, got, ok, k)
+				}
+			}
+			for _, i := range []int{-1, len(keys)} {
+				if got, ok := b.Select(i); ok {
+					t.Fatalf("Select(%d) of %d keys found %v", i, len(keys), got)
+				}
+			}
+			for k := key(-1); k <= 500; k++ {
+				if got, want := b.Rank(k), less(keys, k); got != want {
+					t.Fatalf("Rank(%v) = %d, want %d", k, got, want)
+				}
+			}
+		}
+		mustValidate(t, b)
+	}
+}
+
+func TestEnumerate(t *testing.T) {
+	for _, opts := range []Options[tagged]{{Degree: 3}, {Degree: 3, Multiset: true}} {
+		var (
+			b = NewBTreeWithOptions(opts)
+			r = rand.New(rand.NewSource(8))
+		)
+		for i := 0; i < 1000; i++ {
+			b.Insert(tagged{key(r.Intn(300)), i})
+		}
+		next := 0
+		for i, k := range b.Enumerate() {
+			if i != next {
+				t.Fatalf("Enumerate yielded position %d, want %d", i, next)
+			}
+			if got, ok := b.Select(i); !ok || got != k {
+				t.Fatalf("Enumerate yielded %v at %d, but Select(%d) = %v, %t", k, i, i, got, ok)
+			}
+			next++
+		}
+		if next != b.Len() {
+			t.Fatalf("Enumerate yielded %d keys of %d", next, b.Len())
+		}
+
+		visited := 0
+		b.Enumerate()(func(i int, _ tagged) bool {
+			visited++
+			return i < 9
+		})
+		if visited != 10 {
+			t.Fatalf("Enumerate yielded %d keys, want 10 before yield returned false", visited)
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, multiset := range []bool{false, true} {
+		var (
+			b    = NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: multiset})
+			r    = rand.New(rand.NewSource(9))
+			want = make(map[key]int)
+		)
+		for i := 0; i < 2000; i++ {
+			k := key(r.Intn(200))
+			b.Insert(tagged{k, i})
+			if multiset || want[k] == 0 {
+				want[k]++
+			}
+		}
+		for k := key(-1); k <= 200; k++ {
+			if got := b.Count(tagged{key: k}); got != want[k] {
+				t.Fatalf("Count(%v) in a multiset %t = %d, want %d", k, multiset, got, want[k])
+			}
+		}
+	}
+}
+
+func TestUnion(t *testing.T) {
+	tree := func(lo, hi, step, tag int) *BTree[tagged] {
+		b := NewBTreeWithOptions(Options[tagged]{Degree: 3})
+		for i := lo; i < hi; i += step {
+			b.Insert(tagged{key(i), tag})
+		}
+		return b
+	}
+	for _, c := range []struct {
+		name string
+		a, b *BTree[tagged]
+	}{
+		{"disjoint", tree(0, 1000, 1, 1), tree(1000, 2500, 1, 2)},
+		{"disjoint reversed", tree(1000, 2500, 1, 1), tree(0, 1000, 1, 2)},
+		{"interleaved", tree(0, 2000, 2, 1), tree(1, 2000, 2, 2)},
+		{"overlapping", tree(0, 1500, 1, 1), tree(500, 3000, 3, 2)},
+		{"empty", tree(0, 0, 1, 1), tree(0, 800, 1, 2)},
+	} {
+		var (
+			a, b   = ascending(c.a), ascending(c.b)
+			want   []tagged
+			source = map[key]tagged{}
+		)
+		for _, k := range b {
+			source[k.key] = k
+		}
+		for _, k := range a {
+			source[k.key] = k
+		}
+		for k := key(0); k < 3000; k++ {
+			if v, ok := source[k]; ok {
+				want = append(want, v)
+			}
+		}
+
+		u := c.a.Union(c.b)
+		mustValidate(t, u)
+		if got := ascending(u); !equal(got, want) {
+			t.Fatalf("%s: union holds %v, want %v", c.name, got, want)
+		}
+		naive := tree(0, 0, 1, 0)
+		for _, k := range want {
+			naive.Insert(k)
+		}
+		if u.Height() > naive.Height() {
+			t.Fatalf("%s: union of %d keys is %d levels high, taller than the %d of inserting them", c.name, len(want), u.Height(), naive.Height())
+		}
+
+		// The union is a tree of its own, and neither input is changed.
+		u.Insert(tagged{key(-1), 3})
+		if !equal(ascending(c.a), a) || !equal(ascending(c.b), b) {
+			t.Fatalf("%s: union changed its inputs", c.name)
+		}
+	}
+}
+
+func TestIntersection(t *testing.T) {
+	// multiset returns a multiset holding n keys drawn from 0 to span-1,
+	// each tagged with tag and the number of keys inserted before it.
+	multiset := func(n, span, tag int) *BTree[tagged] {
+		var (
+			b = NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+			r = rand.New(rand.NewSource(int64(n*span + tag)))
+		)
+		for i := 0; i < n; i++ {
+			b.Insert(tagged{key(r.Intn(span)), tag*100000 + i})
+		}
+		return b
+	}
+	for _, c := range []struct {
+		name string
+		a, b *BTree[tagged]
+	}{
+		{"walked in step", multiset(2000, 300, 1), multiset(1500, 300, 2)},
+		{"searching the larger", multiset(8, 2, 1), multiset(3000, 1500, 2)},
+		{"searching the smaller", multiset(3000, 1500, 1), multiset(8, 2, 2)},
+		{"searched for more", multiset(8, 4, 1), multiset(3000, 30, 2)},
+	} {
+		// Each key is held as many times as the tree holding it fewer times
+		// does, by the first values of a.
+		var (
+			a      = ascending(c.a)
+			counts = map[key]int{}
+			want   []tagged
+		)
+		for _, k := range ascending(c.b) {
+			counts[k.key]++
+		}
+		for _, k := range a {
+			if counts[k.key] > 0 {
+				counts[k.key]--
+				want = append(want, k)
+			}
+		}
+
+		i := c.a.Intersection(c.b)
+		mustValidate(t, i)
+		if got := ascending(i); !equal(got, want) {
+			t.Fatalf("%s: intersection holds %v, want %v", c.name, got, want)
+		}
+		if !equal(ascending(c.a), a) {
+			t.Fatalf("%s: intersection changed its input", c.name)
+		}
+	}
+
+	// Sets intersect alike either way.
+	var (
+		a = filled(Options[key]{Degree: 3}, 3000)
+		b = NewBTreeWithOptions(Options[key]{Degree: 3})
+	)
+	for _, k := range []key{-5, 0, 17, 2999, 3000} {
+		b.Insert(k)
+	}
+	for _, i := range []*BTree[key]{a.Intersection(b), b.Intersection(a)} {
+		mustHold(t, i, []key{0, 17, 2999})
+	}
+}
+
+func TestDifference(t *testing.T) {
+	tree := func(keys []key) *BTree[key] {
+		b := NewBTreeWithOptions(Options[key]{Degree: 3})
+		for _, k := range keys {
+			b.Insert(k)
+		}
+		return b
+	}
+	a := filled(Options[key]{Degree: 3}, 2000)
+	for _, c := range []struct {
+		name  string
+		other []key
+		want  []key
+	}{
+		{"full overlap", span(-100, 2100), nil},
+		{"equal", span(0, 2000), nil},
+		{"no overlap", span(2000, 3000), span(0, 2000)},
+		{"empty", nil, span(0, 2000)},
+		{"partial overlap", span(500, 2500), span(0, 500)},
+		{"interleaved", append(span(0, 700), span(1300, 2000)...), span(700, 1300)},
+	} {
+		other := tree(c.other)
+		d := a.Difference(other)
+		mustHold(t, d, c.want)
+
+		// The difference is a tree of its own, and neither input is changed.
+		d.Insert(key(-1))
+		d.Remove(key(1000))
+		mustHold(t, a, span(0, 2000))
+		mustHold(t, other, c.other)
+	}
+
+	// A key held i times by a multiset and j times by other is left i-j times.
+	var (
+		m     = NewBTreeWithOptions(Options[key]{Degree: 3, Multiset: true})
+		other = NewBTreeWithOptions(Options[key]{Degree: 3, Multiset: true})
+	)
+	for i := 0; i < 1000; i++ {
+		m.Insert(key(i % 10))
+		if i%3 == 0 {
+			other.Insert(key(i % 10))
+		}
+	}
+	var want []key
+	for k := key(0); k < 10; k++ {
+		for i := 0; i < m.Count(k)-other.Count(k); i++ {
+			want = append(want, k)
+		}
+	}
+	mustHold(t, m.Difference(other), want)
+}
+
+func TestRemoveIf(t *testing.T) {
+	for _, opts := range []Options[key]{{Degree: 3}, {Degree: 3, LinkLeaves: true}} {
+		b := filled(opts, 5000)
+		var seen []key
+		removed := b.RemoveIf(func(k key) bool {
+			seen = append(seen, k)
+			return k%2 == 1
+		})
+		if removed != 2500 {
+			t.Fatalf("RemoveIf removed %d keys, want 2500", removed)
+		}
+		if !equal(seen, span(0, 5000)) {
+			t.Fatalf("RemoveIf called pred with %v, want each key in order", seen)
+		}
+		var want []key
+		for i := 0; i < 5000; i += 2 {
+			want = append(want, key(i))
+		}
+		mustHold(t, b, want)
+
+		// Removing no keys, and then every key, leaves the tree valid too.
+		if removed := b.RemoveIf(func(key) bool { return false }); removed != 0 {
+			t.Fatalf("RemoveIf removed %d keys, want none", removed)
+		}
+		if removed := b.RemoveIf(func(key) bool { return true }); removed != 2500 {
+			t.Fatalf("RemoveIf removed %d keys, want 2500", removed)
+		}
+		mustHold(t, b, nil)
+		if !b.IsEmpty() || b.Height() != 0 {
+			t.Fatalf("tree without keys is %d levels high", b.Height())
+		}
+	}
+}
+
+// recorded returns the number of keys each internal node of b records beneath
+// it, in the order of a walk of the tree, along with the number it holds.
+func recorded(b *BTree[key]) (counts, sizes []int) {
+	var walk func(n node[key]) int
+	walk = func(n node[key]) int {
+		keys, children := n.contents()
+		size := len(keys)
+		for _, child := range children {
+			size += walk(child)
+		}
+		if children != nil {
+			counts, sizes = append(counts, internalOf[key](n).count), append(sizes, size)
+		}
+		return size
+	}
+	walk(b.root)
+	return counts, sizes
+}
+
+func TestOrderStatistics(t *testing.T) {
+	var (
+		b = NewBTreeWithOptions(Options[key]{Degree: 3, OrderStatistics: true})
+		r = rand.New(rand.NewSource(13))
+	)
+	for i := 0; i < 50000; i++ {
+		switch op := r.Intn(10); {
+		case op < 5:
+			b.Insert(key(r.Intn(5000)))
+		case op < 8:
+			b.Remove(key(r.Intn(5000)))
+		case op < 9 && b.Len() > 0:
+			b.RemoveAt(r.Intn(b.Len()))
+		case i%1000 == 9:
+			left, right := b.Split(key(r.Intn(5000)))
+			b = Concat(left, right)
+		}
+		if i%500 == 0 {
+			counts, sizes := recorded(b)
+			if !equal(counts, sizes) {
+				t.Fatalf("internal nodes record %v keys beneath them but hold %v", counts, sizes)
+			}
+			mustValidate(t, b)
+		}
+	}
+
+	// Without OrderStatistics, no counts are kept, though the methods using
+	// them still count the keys as they go.
+	plain := NewBTreeWithOptions(Options[key]{Degree: 3})
+	b.Ascend(func(k key) bool {
+		plain.Insert(k)
+		return true
+	})
+	for i := 0; i < 1000; i++ {
+		plain.Remove(key(r.Intn(5000)))
+	}
+	counts, _ := recorded(plain)
+	for _, count := range counts {
+		if count != 0 {
+			t.Fatalf("internal nodes of a tree without order statistics record %v keys", counts)
+		}
+	}
+	for _, i := range []int{0, plain.Len() / 2, plain.Len() - 1} {
+		k, _ := plain.Select(i)
+		if rank := plain.Rank(k); rank != i {
+			t.Fatalf("Select(%d) found %v, of rank %d", i, k, rank)
+		}
+	}
+}
+
+// internalOver returns an internal node of a tree configured by cfg holding
+// keys, each child of which is a leaf holding the two keys following the key
+// before it, while the first child holds the two following keys[0]-10.
+func internalOver(cfg *config[key], keys ...key) *childInternalNode[key] {
+	var (
+		n    = newChildInternalNode(cfg)
+		leaf = func(after key) *childLeafNode[key] {
+			l := newChildLeafNode(cfg)
+			l.insertAt(0, item[key]{key: after + 1})
+			l.insertAt(1, item[key]{key: after + 2})
+			return l
+		}
+	)
+	n.children.insert(0, leaf(keys[0]-10))
+	for i, k := range keys {
+		n.insertAt(i, item[key]{key: k})
+		n.children.insert(i+1, leaf(k))
+	}
+	n.recount()
+	return n
+}
+
+// holds fails the test unless the subtree rooted at n holds keys in order, and
+// records as many, with one more child than keys at its own level.
+func holds(t *testing.T, n *childInternalNode[key], own int, keys ...key) {
+	t.Helper()
+	var got []key
+	n.ascend(func(k key) bool {
+		got = append(got, k)
+		return true
+	})
+	if !equal(got, keys) || n.size() != len(keys) {
+		t.Fatalf("node holds %v, recording %d keys, want %v", got, n.size(), keys)
+	}
+	if len(n.keys) != own || len(n.children) != own+1 {
+		t.Fatalf("node has %d keys and %d children, want %d and %d", len(n.keys), len(n.children), own, own+1)
+	}
+}
+
+func TestShuffleInternalNodesAtTheMinimum(t *testing.T) {
+	cfg := newConfig(key.Compare, Options[key]{Degree: 3, OrderStatistics: true})
+
+	// Both nodes hold t-1 keys, the fewest allowed, as the sibling giving up a
+	// key does once the other has taken one from it.
+	var (
+		left  = internalOver(cfg, 10, 20)
+		right = internalOver(cfg, 40, 50)
+	)
+	if last := right.shuffleRight(item[key]{key: 30}, left); last.key != 20 {
+		t.Fatalf("shuffleRight gave up %v, want 20", last.key)
+	}
+	holds(t, left, 1, 1, 2, 10, 11, 12)
+	holds(t, right, 3, 21, 22, 30, 31, 32, 40, 41, 42, 50, 51, 52)
+
+	left, right = internalOver(cfg, 10, 20), internalOver(cfg, 40, 50)
+	if first := left.shuffleLeft(item[key]{key: 30}, right); first.key != 40 {
+		t.Fatalf("shuffleLeft gave up %v, want 40", first.key)
+	}
+	holds(t, left, 3, 1, 2, 10, 11, 12, 20, 21, 22, 30, 31, 32)
+	holds(t, right, 1, 41, 42, 50, 51, 52)
+
+	// A sibling down to a single key can still give it up.
+	left, right = internalOver(cfg, 10), internalOver(cfg, 40, 50)
+	right.shuffleRight(item[key]{key: 30}, left)
+	holds(t, left, 0, 1, 2)
+	holds(t, right, 3, 11, 12, 30, 31, 32, 40, 41, 42, 50, 51, 52)
+	left, right = internalOver(cfg, 10, 20), internalOver(cfg, 40)
+	left.shuffleLeft(item[key]{key: 30}, right)
+	holds(t, left, 3, 1, 2, 10, 11, 12, 20, 21, 22, 30, 31, 32)
+	holds(t, right, 0, 41, 42)
+}
+
+func TestPredecessorAndSuccessor(t *testing.T) {
+	b := NewBTreeWithOptions(Options[key]{Degree: 3})
+	if _, found := b.Predecessor(5); found {
+		t.Fatal("Predecessor found a key in an empty tree")
+	}
+	if _, found := b.Successor(5); found {
+		t.Fatal("Successor found a key in an empty tree")
+	}
+	for i := 0; i <= 1000; i += 10 {
+		b.Insert(key(i))
+	}
+	for _, c := range []struct {
+		key              key
+		prev, next       key
+		hasPrev, hasNext bool
+	}{
+		{key: -5, next: 0, hasNext: true},
+		{key: 0, next: 10, hasNext: true},
+		{key: 5, prev: 0, next: 10, hasPrev: true, hasNext: true},
+		{key: 10, prev: 0, next: 20, hasPrev: true, hasNext: true},
+		{key: 500, prev: 490, next: 510, hasPrev: true, hasNext: true},
+		{key: 503, prev: 500, next: 510, hasPrev: true, hasNext: true},
+		{key: 999, prev: 990, next: 1000, hasPrev: true, hasNext: true},
+		{key: 1000, prev: 990, hasPrev: true},
+		{key: 1005, prev: 1000, hasPrev: true},
+	} {
+		if prev, found := b.Predecessor(c.key); found != c.hasPrev || found && prev != c.prev {
+			t.Errorf("Predecessor(%v) = %v, %t, want %v, %t", c.key, prev, found, c.prev, c.hasPrev)
+		}
+		if next, found := b.Successor(c.key); found != c.hasNext || found && next != c.next {
+			t.Errorf("Successor(%v) = %v, %t, want %v, %t", c.key, next, found, c.next, c.hasNext)
+		}
+	}
+}
+
+func TestLen(t *testing.T) {
+	var (
+		b    = NewBTreeWithOptions(Options[key]{Degree: 3})
+		r    = rand.New(rand.NewSource(10))
+		held = make(map[key]bool)
+	)
+	if b.Len() != 0 {
+		t.Fatalf("an empty tree has Len %d", b.Len())
+	}
+	// The keys are drawn from a small range, so that many inserts find a key
+	// already held and many removals find none, while the tree grows and
+	// shrinks by several levels.
+	for i := 0; i < 5000; i++ {
+		k := key(r.Intn(400))
+		if i%2000 < 1200 {
+			b.Insert(k)
+			held[k] = true
+		} else {
+			b.Remove(k)
+			delete(held, k)
+		}
+		if b.Len() != len(held) {
+			t.Fatalf("Len = %d after %d operations, want %d", b.Len(), i+1, len(held))
+		}
+	}
+	mustValidate(t, b)
+
+	n := b.Len()
+	for k := range held {
+		b.Insert(k)
+	}
+	if b.Len() != n {
+		t.Fatalf("Len = %d after inserting keys already held, want %d", b.Len(), n)
+	}
+	for k := key(400); k < 500; k++ {
+		b.Remove(k)
+	}
+	if b.Len() != n {
+		t.Fatalf("Len = %d after removing keys not held, want %d", b.Len(), n)
+	}
+	for k := range held {
+		b.Remove(k)
+	}
+	if b.Len() != 0 || !b.IsEmpty() {
+		t.Fatalf("Len = %d after removing every key", b.Len())
+	}
+}
+
+func TestPreSplit(t *testing.T) {
+	b := NewBTreeWithOptions(Options[key]{Degree: 3})
+	for i := 0; i < 1000; i += 2 {
+		b.Insert(key(i))
+	}
+	boundaries := []key{250, 501, 750, 1234}
+	if err := b.PreSplit(boundaries); err != nil {
+		t.Fatal(err)
+	}
+	mustValidate(t, b)
+	if b.Len() != 502 {
+		t.Fatalf("tree holds %d keys after PreSplit, want 502 with the placeholders", b.Len())
+	}
+	for _, k := range boundaries {
+		if _, found := b.Search(k); !found {
+			t.Errorf("boundary %v isn't in the tree", k)
+		}
+	}
+
+	// A leaf with enough keys either side of a boundary is split about it,
+	// the boundary separating the two, while one without is left whole.
+	b = NewBTreeWithOptions(Options[key]{Degree: 3})
+	for i := 0; i < 5; i++ {
+		b.Insert(key(i))
+	}
+	if err := b.PreSplit([]key{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	mustHold(t, b, span(0, 5))
+	var got []key
+	b.Separators(func(_ int, k key) bool {
+		got = append(got, k)
+		return true
+	})
+	if !equal(got, []key{2}) {
+		t.Fatalf("PreSplit left separators %v, want [2]", got)
+	}
+
+	// Once the tree is as high as it may be, a boundary which would need a
+	// new level is left where it is, and one which can't be inserted is
+	// refused.
+	b = NewBTreeWithOptions(Options[key]{Degree: 3, MaxHeight: 2})
+	i := 0
+	for ; b.TryInsert(key(i*10)) == nil; i++ {
+	}
+	n := b.Len()
+	if err := b.PreSplit([]key{0, 10, key(i*10 + 5)}); !errors.Is(err, ErrHeightExceeded) {
+		t.Fatalf("PreSplit of a full tree returned %v, want ErrHeightExceeded", err)
+	}
+	mustValidate(t, b)
+	if b.Len() != n || b.Height() > 2 {
+		t.Fatalf("PreSplit left %d keys %d levels high, want %d at most 2", b.Len(), b.Height(), n)
+	}
+
+	b = NewBTreeWithOptions(Options[key]{Degree: 3, MaxHeight: 1})
+	for i := 0; i < 5; i++ {
+		b.Insert(key(i))
+	}
+	if err := b.PreSplit([]key{2}); err != nil {
+		t.Fatal(err)
+	}
+	if b.Height() != 1 {
+		t.Fatalf("PreSplit split a root leaf into %d levels past a MaxHeight of 1", b.Height())
+	}
+}
+
+func TestSwap(t *testing.T) {
+	var (
+		a = filled(Options[key]{Degree: 3}, 100)
+		b = NewBTreeWithOptions(Options[key]{Degree: 5, Multiset: true})
+	)
+	for i := 0; i < 50; i++ {
+		b.Insert(key(1000 + i%10))
+	}
+	va, vb := a.Version(), b.Version()
+	a.Swap(b)
+	if a.Version() <= va || b.Version() <= vb {
+		t.Fatalf("Swap left versions at %d and %d, from %d and %d", a.Version(), b.Version(), va, vb)
+	}
+	mustHold(t, b, span(0, 100))
+	if a.Len() != 50 || a.Count(1003) != 5 {
+		t.Fatalf("swapped multiset holds %d keys and %d of 1003, want 50 and 5", a.Len(), a.Count(1003))
+	}
+
+	// Each tree keeps the configuration that came with its keys, and neither
+	// changes the other.
+	a.Insert(1003)
+	b.Insert(50)
+	b.Insert(100)
+	mustValidate(t, a)
+	if a.Count(1003) != 6 {
+		t.Fatalf("swapped multiset holds %d of 1003, want 6", a.Count(1003))
+	}
+	mustHold(t, b, span(0, 101))
+}
+
+func TestChangedSince(t *testing.T) {
+	type change struct {
+		kind     ChangeKind
+		old, new tagged
+	}
+	cur := NewBTreeWithOptions(Options[tagged]{Degree: 3})
+	for i := 0; i < 200; i++ {
+		cur.Insert(tagged{key(i), 0})
+	}
+	old := cur.Clone()
+	cur.Remove(tagged{key: 5})
+	cur.Insert(tagged{key(7), 1})
+	cur.Insert(tagged{key(8), 0})
+	cur.Insert(tagged{key(250), 0})
+	cur.Remove(tagged{key: 199})
+
+	byTag := func(a, b tagged) bool { return a.tag == b.tag }
+	var got []change
+	ChangedSince(old, cur, byTag, func(kind ChangeKind, o, n tagged) bool {
+		got = append(got, change{kind, o, n})
+		return true
+	})
+	want := []change{
+		{Removed, tagged{5, 0}, tagged{}},
+		{Modified, tagged{7, 0}, tagged{7, 1}},
+		{Removed, tagged{199, 0}, tagged{}},
+		{Added, tagged{}, tagged{250, 0}},
+	}
+	if !equal(got, want) {
+		t.Fatalf("ChangedSince reported %v, want %v", got, want)
+	}
+
+	// Without equal, modified values go unreported, and the walk stops when
+	// fn returns false.
+	got = got[:0]
+	ChangedSince(old, cur, nil, func(kind ChangeKind, o, n tagged) bool {
+		got = append(got, change{kind, o, n})
+		return len(got) < 2
+	})
+	if !equal(got, []change{want[0], want[2]}) {
+		t.Fatalf("ChangedSince without equal reported %v, want %v", got, []change{want[0], want[2]})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	// Trees built in different orders, and of different degrees, hold their
+	// keys in differently shaped nodes.
+	var (
+		a = filled(Options[key]{Degree: 3}, 500)
+		b = NewBTreeWithOptions(Options[key]{Degree: 5})
+	)
+	for i := 499; i >= 0; i-- {
+		b.Insert(key(i))
+	}
+	if !a.Equal(b) || !b.Equal(a) {
+		t.Fatal("trees holding the same keys aren't Equal")
+	}
+	if !NewBTree[key]().Equal(NewBTree[key]()) {
+		t.Fatal("empty trees aren't Equal")
+	}
+	b.Remove(250)
+	if a.Equal(b) {
+		t.Fatal("trees of different sizes are Equal")
+	}
+	b.Insert(500)
+	if a.Equal(b) || b.Equal(a) {
+		t.Fatal("trees of the same size holding different keys are Equal")
+	}
+
+	// In a multiset, each key must be held as many times by both trees.
+	m := NewBTreeWithOptions(Options[key]{Degree: 3, Multiset: true})
+	n := NewBTreeWithOptions(Options[key]{Degree: 3, Multiset: true})
+	for _, k := range []key{1, 1, 2, 3} {
+		m.Insert(k)
+	}
+	for _, k := range []key{1, 2, 2, 3} {
+		n.Insert(k)
+	}
+	if m.Equal(n) {
+		t.Fatal("multisets holding keys different numbers of times are Equal")
+	}
+	n.Remove(2)
+	n.Insert(1)
+	if !m.Equal(n) {
+		t.Fatal("multisets holding the same keys as many times aren't Equal")
+	}
+}
+
+func TestGetOrInsert(t *testing.T) {
+	var (
+		b         = NewBTreeWithOptions(Options[tagged]{Degree: 3})
+		inserted  []tagged
+		conflicts int
+	)
+	b.BeforeInsert = func(k tagged) { inserted = append(inserted, k) }
+	b.OnEqualConflict = func(_, _ tagged) { conflicts++ }
+	for i := 0; i < 100; i++ {
+		if got, found := b.GetOrInsert(tagged{key(i), 1}); found || got != (tagged{key(i), 1}) {
+			t.Fatalf("GetOrInsert of a new key %d = %v, %t", i, got, found)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if got, found := b.GetOrInsert(tagged{key(i), 2}); !found || got != (tagged{key(i), 1}) {
+			t.Fatalf("GetOrInsert of a held key %d = %v, %t, want the value held", i, got, found)
+		}
+	}
+	mustValidate(t, b)
+	if b.Len() != 100 || conflicts != 0 || len(inserted) != 200 {
+		t.Fatalf("%d keys, %d conflicts and %d calls to BeforeInsert, want 100, 0 and 200",
+			b.Len(), conflicts, len(inserted))
+	}
+	for _, k := range ascending(b) {
+		if k.tag != 1 {
+			t.Fatalf("GetOrInsert replaced the value held with %v", k)
+		}
+	}
+
+	m := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+	m.Insert(tagged{1, 1})
+	if _, found := m.GetOrInsert(tagged{1, 2}); found || m.Len() != 2 {
+		t.Fatalf("GetOrInsert in a multiset found a key, leaving %d keys", m.Len())
+	}
+}
+
+func TestReplaceOrInsert(t *testing.T) {
+	b := NewBTreeWithOptions(Options[tagged]{Degree: 3})
+	for i := 0; i < 100; i++ {
+		if old, existed := b.ReplaceOrInsert(tagged{key(i), 1}); existed {
+			t.Fatalf("ReplaceOrInsert of a new key %d replaced %v", i, old)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if old, existed := b.ReplaceOrInsert(tagged{key(i), 2}); !existed || old != (tagged{key(i), 1}) {
+			t.Fatalf("ReplaceOrInsert of a held key %d = %v, %t, want the value replaced", i, old, existed)
+		}
+	}
+	mustValidate(t, b)
+	if b.Len() != 100 {
+		t.Fatalf("tree holds %d keys, want 100", b.Len())
+	}
+	for _, k := range ascending(b) {
+		if want := 2 - int(k.key)%2; k.tag != want {
+			t.Fatalf("tree holds %v, want tag %d", k, want)
+		}
+	}
+
+	m := NewBTreeWithOptions(Options[tagged]{Degree: 3, Multiset: true})
+	m.Insert(tagged{1, 1})
+	if old, existed := m.ReplaceOrInsert(tagged{1, 2}); existed || m.Len() != 2 {
+		t.Fatalf("ReplaceOrInsert in a multiset replaced %v, leaving %d keys", old, m.Len())
+	}
+}
@@ -0,0 +1,173 @@
+package btree
+
+// load replaces the keys of b with keys, which must be sorted in the order of
+// the tree and hold no two keys which compare equal. Rather than inserting the
+// keys one by one, the tree is built directly at the least height able to hold
+// them, visiting each key once in O(n) time. The keys are spread evenly
+// between the children of each node, so that every node is filled alike.
+func (b *BTree[T]) load(keys []T) {
+	items := make([]item[T], len(keys))
+	for i, k := range keys {
+		items[i] = item[T]{k, b.cfg.seq}
+		b.cfg.seq++
+	}
+	b.loadItems(items)
+	b.version++
+}
+
+// loadItems replaces the keys of b with items in the same way as load, keeping
+// the sequence numbers they already have.
+func (b *BTree[T]) loadItems(items []item[T]) {
+	b.size = len(items)
+	if len(items) == 0 {
+		b.root = newRootLeafNode(b.cfg)
+		return
+	}
+	h := 0
+	for b.cfg.maxKeys(h) < len(items) {
+		h++
+	}
+	l := loader[T]{cfg: b.cfg}
+	b.root = l.build(items, h, true).asRoot()
+}
+
+// loader builds a subtree from sorted keys, keeping track of the last leaf it
+// built so that leaves can be linked as they're created.
+type loader[T any] struct {
+	cfg  *config[T]
+	last *childLeafNode[T]
+}
+
+// build returns a subtree of height h holding items, which number between the
+// fewest and the most keys a subtree of height h may hold, unless the subtree
+// is at the root, where there may be fewer. Each internal node takes as few
+// children as can hold its keys, but never fewer than t unless it's the root,
+// and divides the keys evenly between them.
+func (l *loader[T]) build(items []item[T], h int, root bool) childNode[T] {
+	if h == 0 {
+		leaf := newChildLeafNode(l.cfg)
+		for _, it := range items {
+			leaf.insertAt(len(leaf.keys), it)
+		}
+		if l.cfg.linkLeaves {
+			leaf.prev = l.last
+			if l.last != nil {
+				l.last.next = leaf
+			}
+			l.last = leaf
+		}
+		return leaf
+	}
+
+	var (
+		n    = newChildInternalNode(l.cfg)
+		most = l.cfg.maxKeys(h - 1)
+		c    = (len(items) + 1 + most) / (most + 1)
+	)
+	if !root && c < l.cfg.t {
+		c = l.cfg.t
+	}
+	total := len(items) - (c - 1)
+	for i := 0; i < c; i++ {
+		size := total / c
+		if i < total%c {
+			size++
+		}
+		n.children.insert(i, l.build(items[:size], h-1, false))
+		items = items[size:]
+		if i < c-1 {
+			n.insertAt(i, items[0])
+			items = items[1:]
+		}
+	}
+	n.recount()
+	return n
+}
+
+// maxKeys returns the most keys a subtree of height h may hold, (2t)ʰ⁺¹-1.
+func (c *config[T]) maxKeys(h int) int {
+	n := 2 * c.t
+	for ; h > 0; h-- {
+		n *= 2 * c.t
+	}
+	return n - 1
+}
+
+// CompactSparse rebuilds those subtrees of the tree in which the keys fill less
+// than threshold of the room in their nodes on average, where threshold is a
+// fraction between 0 and 1, leaving denser subtrees as they are. The tree is
+// walked from the root down, and each sparse subtree is rebuilt in place with
+// the bulk loader at the same height, taking fewer, fuller nodes. Subtrees of
+// denser subtrees are examined in turn, so that sparse regions are found
+// wherever they lie. Every key is kept, along with its sequence number, and
+// the tree remains valid throughout.
+func (b *BTree[T]) CompactSparse(threshold float64) {
+	if sparse[T](b.cfg, b.root, threshold) {
+		var items []item[T]
+		b.root.ascendItems(func(it item[T]) bool {
+			items = append(items, it)
+			return true
+		})
+		b.loadItems(items)
+		return
+	}
+	b.root = b.root.mutable(b.cfg)
+	if root, ok := b.root.(*rootInternalNode[T]); ok {
+		root.compactSparse(threshold)
+	}
+}
+
+// compactSparse rebuilds the sparse subtrees below the internal node n, or
+// examines the subtrees of those which aren't sparse.
+func (n *baseInternalNode[T]) compactSparse(threshold float64) {
+	for i, child := range n.children {
+		c, ok := child.(*childInternalNode[T])
+		if !ok {
+			return
+		}
+		if sparse[T](n.cfg, c, threshold) {
+			n.children[i] = rebuild[T](n.cfg, c)
+		} else {
+			n.mutableChild(i).(*childInternalNode[T]).compactSparse(threshold)
+		}
+	}
+}
+
+// rebuild returns a subtree of the same height as the subtree rooted at n,
+// built anew from its keys, with its leaves taking the place of those of n in
+// the chain of leaves if the tree links its leaves.
+func rebuild[T any](cfg *config[T], n childNode[T]) childNode[T] {
+	var items []item[T]
+	n.ascendItems(func(it item[T]) bool {
+		items = append(items, it)
+		return true
+	})
+	var (
+		l     = loader[T]{cfg: cfg, last: n.firstLeaf().prev}
+		next  = n.lastLeaf().next
+		built = l.build(items, height[T](n), false)
+	)
+	if cfg.linkLeaves {
+		l.last.next = next
+		if next != nil {
+			next.prev = l.last
+		}
+	}
+	return built
+}
+
+// sparse reports whether the keys of the subtree rooted at n fill less than
+// threshold of the room in its nodes.
+func sparse[T any](cfg *config[T], n node[T], threshold float64) bool {
+	nodes := 0
+	var count func(n node[T])
+	count = func(n node[T]) {
+		nodes++
+		_, children := n.contents()
+		for _, child := range children {
+			count(child)
+		}
+	}
+	count(n)
+	return float64(n.size()) < threshold*float64(nodes*(2*cfg.t-1))
+}
@@ -0,0 +1,66 @@
+package btree
+
+import "testing"
+
+// nodes returns the number of nodes in the tree b.
+func nodes[T any](b *BTree[T]) int {
+	var walk func(n node[T]) int
+	walk = func(n node[T]) int {
+		count := 1
+		_, children := n.contents()
+		for _, c := range children {
+			count += walk(c)
+		}
+		return count
+	}
+	return walk(b.root)
+}
+
+func TestCompactSparse(t *testing.T) {
+	for _, opts := range []Options[key]{{Degree: 3}, {Degree: 3, LinkLeaves: true}} {
+		b := NewBTreeWithOptions(opts)
+		for i := 0; i < 4000; i++ {
+			b.Insert(key(i))
+		}
+		// Most of the keys of the lower half are removed, leaving its nodes
+		// sparse, while the upper half stays as it was.
+		var want []key
+		for i := 0; i < 4000; i++ {
+			if i >= 2000 || i%10 == 0 {
+				want = append(want, key(i))
+			} else {
+				b.Remove(key(i))
+			}
+		}
+		var snapshot *BTree[key]
+		if !opts.LinkLeaves {
+			snapshot = b.Clone()
+		}
+
+		before := nodes(b)
+		b.CompactSparse(0)
+		if got := nodes(b); got != before {
+			t.Fatalf("CompactSparse(0) changed %d nodes to %d", before, got)
+		}
+		b.CompactSparse(0.75)
+		mustHold(t, b, want)
+		if after := nodes(b); after >= before {
+			t.Fatalf("CompactSparse(0.75) left %d nodes of %d", after, before)
+		}
+		if opts.LinkLeaves {
+			var scanned []key
+			b.ScanLeaves(func(k key) bool {
+				scanned = append(scanned, k)
+				return true
+			})
+			if !equal(scanned, want) {
+				t.Fatalf("ScanLeaves walked %d keys after CompactSparse, want %d", len(scanned), len(want))
+			}
+		} else {
+			mustHold(t, snapshot, want)
+		}
+		b.Insert(key(5))
+		b.Remove(key(3000))
+		mustValidate(t, b)
+	}
+}
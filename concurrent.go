@@ -0,0 +1,95 @@
+package btree
+
+import "sync"
+
+// ConcurrentBTree wraps a BTree with a sync.RWMutex so that it may be used by
+// several goroutines at once. Searches take the read lock, and so may run
+// alongside one another, while modifications take the write lock.
+type ConcurrentBTree[T Comparable[T]] struct {
+	mu   sync.RWMutex
+	tree *BTree[T]
+}
+
+// NewConcurrentBTree creates an empty ConcurrentBTree.
+func NewConcurrentBTree[T Comparable[T]]() *ConcurrentBTree[T] {
+	return &ConcurrentBTree[T]{tree: NewBTree[T]()}
+}
+
+// Search searches the tree for the value matching key under the read lock.
+func (c *ConcurrentBTree[T]) Search(key T) (T, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Search(key)
+}
+
+// Contains reports whether the tree holds a value matching key under the read
+// lock.
+func (c *ConcurrentBTree[T]) Contains(key T) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Contains(key)
+}
+
+// Ascend calls fn for each key in the tree in ascending order, until fn returns
+// false, holding the read lock throughout the walk. Writers wait for the walk
+// to finish, and fn must not modify the tree, which would deadlock; to visit
+// the keys without holding the lock, take a copy of them with ToSlice first.
+func (c *ConcurrentBTree[T]) Ascend(fn func(T) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.tree.Ascend(fn)
+}
+
+// ToSlice returns the keys of the tree in ascending order, copied under the
+// read lock, as a snapshot which may be used once the lock is released.
+func (c *ConcurrentBTree[T]) ToSlice() []T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.ToSlice()
+}
+
+// View calls fn with the underlying tree while holding the read lock, so that
+// any of the methods of BTree which only read the tree, including its
+// iterators, may be used alongside other readers. fn must only read the tree,
+// and so mustn't call Clone on it, which changes the tree it clones; Clone the
+// ConcurrentBTree instead. b must not be retained or used once fn returns, as
+// with Txn.
+func (c *ConcurrentBTree[T]) View(fn func(b *BTree[T])) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	fn(c.tree)
+}
+
+// Clone returns a snapshot of the tree, a BTree sharing the nodes of the tree
+// until either changes them, as for BTree.Clone. Cloning gives the tree a
+// configuration of its own, and so changes it, so the clone is taken under the
+// write lock. The snapshot is independent of the tree, and needn't be locked.
+func (c *ConcurrentBTree[T]) Clone() *BTree[T] {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tree.Clone()
+}
+
+// Insert inserts key into the tree under the write lock.
+func (c *ConcurrentBTree[T]) Insert(key T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tree.Insert(key)
+}
+
+// Remove removes the value matching key from the tree under the write lock.
+func (c *ConcurrentBTree[T]) Remove(key T) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tree.Remove(key)
+}
+
+// Txn calls fn with the underlying tree while holding the write lock, so that
+// a batch of operations applied by fn is seen by other goroutines all at once,
+// and takes the lock only once for the whole batch. b must not be retained or
+// used once fn returns, as it's then no longer protected by the lock.
+func (c *ConcurrentBTree[T]) Txn(fn func(b *BTree[T])) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fn(c.tree)
+}
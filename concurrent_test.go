@@ -0,0 +1,101 @@
+package btree
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConcurrentBTreeClone(t *testing.T) {
+	c := NewConcurrentBTree[key]()
+	for i := 0; i < 1000; i++ {
+		c.Insert(key(i))
+	}
+
+	// Snapshots are taken while other goroutines read and change the tree.
+	var (
+		wg        sync.WaitGroup
+		snapshots = make([]*BTree[key], 8)
+	)
+	for g := range snapshots {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			snapshots[g] = c.Clone()
+		}()
+		go func() {
+			defer wg.Done()
+			c.View(func(b *BTree[key]) {
+				for k := range b.All() {
+					if _, found := b.Search(k); !found {
+						t.Errorf("%v is walked but not found", k)
+					}
+				}
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			c.Insert(key(1000 + g))
+		}()
+	}
+	wg.Wait()
+
+	for _, snapshot := range snapshots {
+		mustValidate(t, snapshot)
+		keys := ascending(snapshot)
+		if !equal(keys[:1000], span(0, 1000)) {
+			t.Fatalf("snapshot holds %v", keys)
+		}
+		before := len(keys)
+		snapshot.Insert(key(-1))
+		c.Remove(key(0))
+		if snapshot.Len() != before+1 || !c.Contains(key(1)) || c.Contains(key(0)) {
+			t.Fatal("snapshot isn't independent of the tree")
+		}
+	}
+}
+
+func TestConcurrentBTreeTxn(t *testing.T) {
+	c := NewConcurrentBTree[key]()
+
+	// Each batch inserts a pair of keys, so readers always see an even number
+	// of keys, and never one key of a pair without the other.
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				k := key(2 * (g*100 + i))
+				c.Txn(func(b *BTree[key]) {
+					b.Insert(k)
+					b.Insert(k + 1)
+				})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				c.View(func(b *BTree[key]) {
+					if b.Len()%2 != 0 {
+						t.Errorf("a reader saw %d keys, half of a batch", b.Len())
+					}
+					for k := range b.All() {
+						if _, found := b.Search(k ^ 1); !found {
+							t.Errorf("a reader saw %v without %v", k, k^1)
+						}
+					}
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	var keys []key
+	c.View(func(b *BTree[key]) {
+		mustValidate(t, b)
+		keys = ascending(b)
+	})
+	if !equal(keys, span(0, 1600)) {
+		t.Fatalf("batches left %d keys, want 1600", len(keys))
+	}
+}
@@ -0,0 +1,442 @@
+package btree
+
+// cursor walks the keys of a subtree in order, one key at a time, so that
+// several trees can be walked side by side. It holds the path from the root of
+// the subtree down to the node of the next key, recording for each node on the
+// path the position of the next key to be visited within it. A cursor set to
+// walk only internal nodes leaves leaves off its path, and so visits just the
+// keys separating one leaf from the next.
+type cursor[T any] struct {
+	path     []position[T]
+	internal bool
+}
+
+// position is a node on the path of a cursor, along with i, the index of the
+// next key of the node to be visited.
+type position[T any] struct {
+	keys     list[T]
+	children list[childNode[T]]
+	i        int
+}
+
+// newCursor returns a cursor positioned before the first key of the subtree
+// rooted at n.
+func newCursor[T any](n node[T]) *cursor[T] {
+	c := &cursor[T]{}
+	c.descend(n)
+	return c
+}
+
+// descend extends the path of c down the leftmost spine of the subtree rooted
+// at n.
+func (c *cursor[T]) descend(n node[T]) {
+	for {
+		keys, children := n.contents()
+		if children == nil && c.internal {
+			return
+		}
+		c.path = append(c.path, position[T]{keys, children, 0})
+		if children == nil {
+			return
+		}
+		n = children[0]
+	}
+}
+
+// next returns the next key of the walk, moving c past it, or false once every
+// key has been visited. The child following the key of an internal node is
+// descended as soon as the key is returned, so the node at the end of the path
+// always holds the next key, unless it has been exhausted.
+func (c *cursor[T]) next() (key T, ok bool) {
+	for len(c.path) > 0 {
+		p := &c.path[len(c.path)-1]
+		if p.i == len(p.keys) {
+			c.path = c.path[:len(c.path)-1]
+			continue
+		}
+		key = p.keys[p.i]
+		p.i++
+		if p.children == nil {
+			return key, true
+		}
+		child := p.children[p.i]
+		if _, leaf := child.(*childLeafNode[T]); !leaf || !c.internal {
+			c.descend(child)
+		}
+		return key, true
+	}
+	return
+}
+
+// Position is the place of a key in a tree, as found by Locate, from which
+// NextFrom and PrevFrom step to the keys either side of it without searching
+// the tree again. A Position holds the path down the tree to its key, so it's
+// invalidated by any change to the tree, after which it must not be used.
+// Stepping moves the Position itself, and so any copies of it, along with the
+// one returned.
+type Position[T any] struct {
+	path *[]position[T]
+}
+
+// Locate searches the tree for the value matching key like Search, returning
+// its Position as well if such a value exists.
+func (b BTree[T]) Locate(key T) (T, Position[T], bool) {
+	var (
+		path []position[T]
+		n    node[T] = b.root
+	)
+	for {
+		keys, children := n.contents()
+		i, found := b.cfg.probe(keys, children, key)
+		path = append(path, position[T]{keys, children, i})
+		if found {
+			return keys[i], Position[T]{&path}, true
+		}
+		if children == nil {
+			var zero T
+			return zero, Position[T]{}, false
+		}
+		n = children[i]
+	}
+}
+
+// NextFrom moves pos on to the key following it in ascending order, returning
+// the key, or false if pos was at the last key of the tree. Only the path from
+// the node of one key to that of the next is walked, taking O(1) amortized
+// time over a series of steps.
+func (b BTree[T]) NextFrom(pos Position[T]) (T, Position[T], bool) {
+	return pos.step(stepNext[T])
+}
+
+// PrevFrom moves pos back to the key before it in ascending order, returning
+// the key, or false if pos was at the first key of the tree, in the same way
+// as NextFrom.
+func (b BTree[T]) PrevFrom(pos Position[T]) (T, Position[T], bool) {
+	return pos.step(stepPrev[T])
+}
+
+// step moves pos with step, returning the key it then leads to.
+func (pos Position[T]) step(step func([]position[T]) []position[T]) (T, Position[T], bool) {
+	if pos.path == nil || len(*pos.path) == 0 {
+		var zero T
+		return zero, pos, false
+	}
+	*pos.path = step(*pos.path)
+	k, ok := keyOf(*pos.path)
+	return k, pos, ok
+}
+
+// The functions below work on paths leading down a tree to a key, in which
+// the last position holds the index of the key within its node, and each
+// position above it the index of the child the path descends into.
+
+// keyOf returns the key path leads to, or false if path is empty.
+func keyOf[T any](path []position[T]) (key T, ok bool) {
+	if len(path) == 0 {
+		return
+	}
+	last := path[len(path)-1]
+	return last.keys[last.i], true
+}
+
+// stepNext moves path on to the key following the one it leads to, returning
+// the path, which is empty if the key was the last of the tree. The key of an
+// internal node is followed by the first key of the subtree to its right,
+// while that of a leaf is followed by the next key of the leaf, or else that
+// of the nearest ancestor with a key to the right of the path.
+func stepNext[T any](path []position[T]) []position[T] {
+	last := &path[len(path)-1]
+	last.i++
+	if last.children != nil {
+		return appendFirst(path, last.children[last.i])
+	}
+	return climbNext(path)
+}
+
+// stepPrev moves path back to the key before the one it leads to, returning
+// the path, which is empty if the key was the first of the tree, in the same
+// way as stepNext.
+func stepPrev[T any](path []position[T]) []position[T] {
+	last := &path[len(path)-1]
+	if last.children != nil {
+		return appendLast(path, last.children[last.i])
+	}
+	return climbPrev(path)
+}
+
+// climbNext pops each position from the end of path which has run past the
+// last key of its node, returning the path to the next key left above them.
+func climbNext[T any](path []position[T]) []position[T] {
+	for len(path) > 0 && path[len(path)-1].i == len(path[len(path)-1].keys) {
+		path = path[:len(path)-1]
+	}
+	return path
+}
+
+// climbPrev pops each position from the end of path which lies before the
+// first key of its node, returning the path to the key before the last of
+// those left, which precedes the subtree the path then descended into.
+func climbPrev[T any](path []position[T]) []position[T] {
+	for len(path) > 0 && path[len(path)-1].i == 0 {
+		path = path[:len(path)-1]
+	}
+	if len(path) > 0 {
+		path[len(path)-1].i--
+	}
+	return path
+}
+
+// appendFirst extends path down the leftmost spine of the subtree rooted at n
+// to its first key.
+func appendFirst[T any](path []position[T], n node[T]) []position[T] {
+	for {
+		keys, children := n.contents()
+		path = append(path, position[T]{keys, children, 0})
+		if children == nil {
+			return path
+		}
+		n = children[0]
+	}
+}
+
+// appendLast extends path down the rightmost spine of the subtree rooted at n
+// to its last key.
+func appendLast[T any](path []position[T], n node[T]) []position[T] {
+	for {
+		keys, children := n.contents()
+		if children == nil {
+			return append(path, position[T]{keys, children, len(keys) - 1})
+		}
+		path = append(path, position[T]{keys, children, len(keys)})
+		n = children[len(keys)]
+	}
+}
+
+// walkInStep walks the keys of a and b side by side in ascending order, where
+// both trees are ordered alike, calling fn with each key held by either tree
+// until fn returns false. Where the two trees hold keys comparing equal, fn is
+// called once with both, and inA and inB both set. Otherwise, only the key of
+// the tree holding it is set, and the other is the zero value of T.
+func walkInStep[T any](a, b *BTree[T], fn func(ka, kb T, inA, inB bool) bool) {
+	var (
+		ca      = newCursor[T](a.root)
+		cb      = newCursor[T](b.root)
+		ka, oka = ca.next()
+		kb, okb = cb.next()
+		zero    T
+	)
+	for oka || okb {
+		var compared int
+		switch {
+		case !okb:
+			compared = -1
+		case !oka:
+			compared = 1
+		default:
+			compared = a.cfg.compare(ka, kb)
+		}
+		switch {
+		case compared < 0:
+			if !fn(ka, zero, true, false) {
+				return
+			}
+			ka, oka = ca.next()
+		case compared > 0:
+			if !fn(zero, kb, false, true) {
+				return
+			}
+			kb, okb = cb.next()
+		default:
+			if !fn(ka, kb, true, true) {
+				return
+			}
+			ka, oka = ca.next()
+			kb, okb = cb.next()
+		}
+	}
+}
+
+// probeInStep calls fn in ascending order with each key of a matched by a key
+// of b, where both trees are ordered alike, along with the key matching it. As
+// in walkInStep, each key of a run of equal keys in a is matched with one of
+// the matching run in b, in order, so fn is called as many times as the
+// shorter of the two runs holds keys. Each run of a is found in b with Seek,
+// taking O(m·logₜn) time for the m keys of a however far apart the runs lie.
+func probeInStep[T any](a, b *BTree[T], fn func(ka, kb T)) {
+	var (
+		run   *Cursor[T]
+		start T
+	)
+	a.root.ascend(func(ka T) bool {
+		if run == nil || a.cfg.compare(start, ka) != 0 {
+			run, start = b.Seek(ka), ka
+		}
+		if kb, ok := run.Value(); ok && a.cfg.compare(ka, kb) == 0 {
+			fn(ka, kb)
+			run.Next()
+		}
+		return true
+	})
+}
+
+// Cursor is a place among the keys of a tree, found by Seek, from which the
+// keys either side may be visited one at a time, pausing between steps. As
+// with a Position, a Cursor holds the path down the tree to its key, each step
+// taking O(1) amortized time. A Cursor only reads the tree, and what it does
+// once the tree has been changed is undefined.
+type Cursor[T any] struct {
+	root  node[T]
+	path  []position[T]
+	end   int   // With an empty path, 1 if past the last key, or -1 if before the first
+	taken []int // The index taken at each level by an insertion the cursor guides
+}
+
+// Seek returns a Cursor at the first key of the tree greater than or equal to
+// key, or past the last key of the tree if there's no such key.
+func (b BTree[T]) Seek(key T) *Cursor[T] {
+	var (
+		c         = &Cursor[T]{root: b.root}
+		n node[T] = b.root
+	)
+	for {
+		keys, children := n.contents()
+		i, found := find(keys, key, b.cfg.lower)
+		c.path = append(c.path, position[T]{keys, children, i})
+		if found || children == nil {
+			break
+		}
+		n = children[i]
+	}
+
+	// Where key falls after the last key of a leaf, the key following it is
+	// that of the nearest ancestor with a key to the right of the path.
+	if c.path = climbNext(c.path); len(c.path) == 0 {
+		c.end = 1
+	}
+	return c
+}
+
+// Value returns the key at the cursor, or false if the cursor has moved past
+// either end of the tree.
+func (c *Cursor[T]) Value() (T, bool) {
+	return keyOf(c.path)
+}
+
+// Next moves the cursor on to the following key, returning it, or false if the
+// cursor moves past the last key of the tree. A cursor before the first key of
+// the tree moves to the first key.
+func (c *Cursor[T]) Next() (T, bool) {
+	switch {
+	case len(c.path) > 0:
+		c.path = stepNext(c.path)
+	case c.end < 0 && numKeys[T](c.root) > 0:
+		c.path = appendFirst(c.path, c.root)
+	}
+	if len(c.path) == 0 {
+		c.end = 1
+	}
+	return keyOf(c.path)
+}
+
+// Prev moves the cursor back to the key before it, returning it, or false if
+// the cursor moves before the first key of the tree. A cursor past the last key
+// of the tree moves to the last key.
+func (c *Cursor[T]) Prev() (T, bool) {
+	switch {
+	case len(c.path) > 0:
+		c.path = stepPrev(c.path)
+	case c.end > 0 && numKeys[T](c.root) > 0:
+		c.path = appendLast(c.path, c.root)
+	}
+	if len(c.path) == 0 {
+		c.end = -1
+	}
+	return keyOf(c.path)
+}
+
+// InsertWithHint inserts key into the tree as Insert does, guided by hint, a
+// cursor at a key near where key belongs, such as one left by an earlier call.
+// At each level of the descent, the position at which the path of hint crosses
+// the level is tried first, and then the one after it, taking at most four
+// comparisons to confirm where key belongs. Only where neither holds it does
+// the descent fall back to a binary search of the node, so hint may be stale,
+// or from before the tree was changed, without harm. Once key is inserted, hint
+// is moved to it, so that a run of ascending keys, each inserted with the same
+// hint, finds its way down the tree in a few comparisons at each level. With a
+// nil hint, key is inserted as by Insert. InsertWithHint panics with
+// ErrHeightExceeded where TryInsert would return it.
+func (b *BTree[T]) InsertWithHint(key T, hint *Cursor[T]) {
+	if hint == nil {
+		b.Insert(key)
+		return
+	}
+	hint.taken = hint.taken[:0]
+	if _, _, err := b.insert(key, true, hint); err != nil {
+		panic(err)
+	}
+
+	// The path is rebuilt along the positions taken, every one but the last of
+	// which is the index of a child.
+	var n node[T] = b.root
+	hint.root, hint.path, hint.end = b.root, hint.path[:0], 0
+	for j, i := range hint.taken {
+		keys, children := n.contents()
+		hint.path = append(hint.path, position[T]{keys, children, i})
+		if j < len(hint.taken)-1 {
+			n = children[i]
+		}
+	}
+}
+
+// place finds the position of k in keys for an insertion guided by the
+// cursor c, as find does with the placed comparison of cfg. The position of c
+// at the level of keys, and the one after it, are tried before searching keys,
+// which is all that is done if c is nil.
+func (c *Cursor[T]) place(cfg *config[T], keys list[T], k T) (int, bool) {
+	if c != nil {
+		if depth := len(c.taken); depth < len(c.path) {
+			h := c.path[depth].i
+			if i, found, ok := cfg.fits(keys, k, h); ok {
+				return i, found
+			}
+			if i, found, ok := cfg.fits(keys, k, h+1); ok {
+				return i, found
+			}
+		}
+	}
+	return find(keys, k, cfg.placed)
+}
+
+// fits reports whether k belongs at the i-th position of keys, between the keys
+// either side of it, or else matches one of them, returning the position of the
+// key matched if so.
+func (c *config[T]) fits(keys list[T], k T, i int) (int, bool, bool) {
+	if i < 0 || i > len(keys) {
+		return 0, false, false
+	}
+	if i > 0 {
+		switch compared := c.placed(k, keys[i-1]); {
+		case compared == 0:
+			return i - 1, true, true
+		case compared < 0:
+			return 0, false, false
+		}
+	}
+	if i < len(keys) {
+		switch compared := c.placed(k, keys[i]); {
+		case compared == 0:
+			return i, true, true
+		case compared > 0:
+			return 0, false, false
+		}
+	}
+	return i, false, true
+}
+
+// took records i as the position taken at the next level of the descent of an
+// insertion guided by the cursor c, if not nil.
+func (c *Cursor[T]) took(i int) {
+	if c != nil {
+		c.taken = append(c.taken, i)
+	}
+}
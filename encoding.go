@@ -0,0 +1,213 @@
+package btree
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WriteTo writes the keys of the tree to w in ascending order, implementing
+// io.WriterTo. Each key is encoded by its MarshalBinary method, so the keys
+// must implement encoding.BinaryMarshaler. The output begins with the number
+// of keys, followed by each encoded key prefixed with its length in bytes, all
+// written as uvarints.
+func (b BTree[T]) WriteTo(w io.Writer) (n int64, err error) {
+	var buf [binary.MaxVarintLen64]byte
+	write := func(p []byte) bool {
+		var m int
+		m, err = w.Write(p)
+		n += int64(m)
+		return err == nil
+	}
+	if !write(buf[:binary.PutUvarint(buf[:], uint64(b.size))]) {
+		return
+	}
+	b.root.ascend(func(k T) bool {
+		m, ok := any(k).(encoding.BinaryMarshaler)
+		if !ok {
+			err = fmt.Errorf("btree: %T does not implement encoding.BinaryMarshaler", k)
+			return false
+		}
+		data, merr := m.MarshalBinary()
+		if merr != nil {
+			err = merr
+			return false
+		}
+		return write(buf[:binary.PutUvarint(buf[:], uint64(len(data)))]) && write(data)
+	})
+	return
+}
+
+// ReadFrom replaces the keys of the tree with those written by WriteTo,
+// implementing io.ReaderFrom. Each key is decoded by the UnmarshalBinary
+// method of *T, which must implement encoding.BinaryUnmarshaler. Only the
+// bytes of the encoded tree are read, so r may go on to hold other data. The
+// tree keeps its configuration, or takes the default configuration if it's
+// the zero BTree, and is built from the keys directly in O(n) time, after
+// checking that they're in the order of the tree, as for GobDecode.
+func (b *BTree[T]) ReadFrom(r io.Reader) (n int64, err error) {
+	var (
+		cr   = &countingReader{r: r}
+		keys []T
+	)
+	// n is always the number of bytes read, however ReadFrom returns.
+	defer func() {
+		n = cr.n
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}()
+	count, err := binary.ReadUvarint(cr)
+	if err != nil {
+		return
+	}
+	for ; count > 0; count-- {
+		var size uint64
+		if size, err = binary.ReadUvarint(cr); err != nil {
+			return
+		}
+		var data []byte
+		if data, err = io.ReadAll(io.LimitReader(cr, int64(size))); err != nil {
+			return
+		}
+		if uint64(len(data)) < size {
+			return 0, io.ErrUnexpectedEOF
+		}
+		var k T
+		u, ok := any(&k).(encoding.BinaryUnmarshaler)
+		if !ok {
+			return 0, fmt.Errorf("btree: %T does not implement encoding.BinaryUnmarshaler", &k)
+		}
+		if err = u.UnmarshalBinary(data); err != nil {
+			return
+		}
+		keys = append(keys, k)
+	}
+	err = b.loadDecoded(keys)
+	return
+}
+
+// countingReader counts the bytes read from r, reading a byte at a time for
+// io.ByteReader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	m, err := c.r.Read(p)
+	c.n += int64(m)
+	return m, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	var p [1]byte
+	if _, err := io.ReadFull(c, p[:]); err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// GobEncode encodes the keys of the tree in ascending order with encoding/gob,
+// implementing gob.GobEncoder, so the keys must themselves be encodable by gob.
+// Only the keys are encoded, not the configuration of the tree.
+func (b *BTree[T]) GobEncode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(b.ToSlice()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// GobDecode replaces the keys of the tree with those encoded by GobEncode,
+// implementing gob.GobDecoder. The tree keeps its configuration, or takes the
+// default configuration if it's the zero BTree. As the keys are already
+// sorted, the tree is built from them directly in O(n) time, after checking
+// that they're in the order of the tree.
+func (b *BTree[T]) GobDecode(data []byte) error {
+	var keys []T
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&keys); err != nil {
+		return err
+	}
+	return b.loadDecoded(keys)
+}
+
+// MarshalJSON encodes the keys of the tree as a JSON array in ascending order,
+// implementing json.Marshaler, so the keys must themselves be encodable by
+// encoding/json. An empty tree is encoded as [].
+func (b *BTree[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(b.ToSlice())
+}
+
+// UnmarshalJSON replaces the keys of the tree with those of a JSON array in
+// ascending order, implementing json.Unmarshaler, in the same way as
+// GobDecode.
+func (b *BTree[T]) UnmarshalJSON(data []byte) error {
+	var keys []T
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	return b.loadDecoded(keys)
+}
+
+// loadDecoded replaces the keys of the tree with decoded keys, giving the zero
+// BTree the default configuration, once keys are known to be in the order of
+// the tree. The zero BTree has no order of its own, and so is ordered by the
+// Compare method of its keys, which they must have.
+func (b *BTree[T]) loadDecoded(keys []T) error {
+	if b.cfg == nil {
+		var zero T
+		if _, ok := any(zero).(Comparable[T]); !ok {
+			return errors.New("btree: keys decoded into the zero BTree have no Compare method")
+		}
+		*b = *newBTree(func(a, c T) int { return any(a).(Comparable[T]).Compare(c) }, Options[T]{})
+	}
+	for i := 1; i < len(keys); i++ {
+		if b.cfg.upper(keys[i], keys[i-1]) <= 0 {
+			return errors.New("btree: decoded keys aren't in the order of the tree")
+		}
+	}
+	b.load(keys)
+	return nil
+}
+
+// EncodedSize returns the number of bytes WriteTo would write for the tree,
+// without encoding any keys, where sizeOf returns the length of the encoding
+// of a key by its MarshalBinary method. The framing of the keys is added to the
+// sizes reported by sizeOf in a single walk of the tree.
+func (b BTree[T]) EncodedSize(sizeOf func(T) int) int {
+	size := uvarintLen(uint64(b.size))
+	b.root.ascend(func(k T) bool {
+		n := sizeOf(k)
+		size += uvarintLen(uint64(n)) + n
+		return true
+	})
+	return size
+}
+
+// uvarintLen returns the number of bytes taken to write x as a uvarint.
+func uvarintLen(x uint64) int {
+	var buf [binary.MaxVarintLen64]byte
+	return binary.PutUvarint(buf[:], x)
+}
+
+// WriteMerged writes the union of the keys of a and b to w in ascending order,
+// where both trees are ordered alike, encoding each key with enc and returning
+// the first error it returns. The two trees are walked in step and merged as
+// they're written, so the union is never held in memory. Where a and b hold keys
+// which compare equal, only that of a is written.
+func WriteMerged[T any](w io.Writer, a, b *BTree[T], enc func(io.Writer, T) error) (err error) {
+	walkInStep(a, b, func(ka, kb T, inA, _ bool) bool {
+		if !inA {
+			ka = kb
+		}
+		err = enc(w, ka)
+		return err == nil
+	})
+	return
+}
@@ -0,0 +1,226 @@
+package btree
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+)
+
+// word is a key encoded by WriteTo as its bytes.
+type word string
+
+func (a word) Compare(b word) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func (w word) MarshalBinary() ([]byte, error) {
+	return []byte(w), nil
+}
+
+func (w *word) UnmarshalBinary(data []byte) error {
+	*w = word(data)
+	return nil
+}
+
+// words returns a tree of degree 3 holding n distinct words.
+func words(n int) *BTree[word] {
+	b := NewBTreeWithDegree[word](3)
+	for i := 0; i < n; i++ {
+		b.Insert(word("w" + string(rune('a'+i%26)) + string(rune('a'+i/26))))
+	}
+	return b
+}
+
+func TestReadFromRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 500} {
+		var (
+			b   = words(size)
+			buf bytes.Buffer
+		)
+		written, err := b.WriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoded := b.EncodedSize(func(w word) int { return len(w) }); int64(encoded) != written {
+			t.Fatalf("EncodedSize = %d, but WriteTo wrote %d bytes", encoded, written)
+		}
+		buf.WriteString("trailing")
+
+		var read BTree[word]
+		n, err := read.ReadFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != written {
+			t.Fatalf("ReadFrom read %d bytes, want %d", n, written)
+		}
+		if buf.String() != "trailing" {
+			t.Fatalf("ReadFrom left %q unread, want %q", buf.String(), "trailing")
+		}
+		mustValidate(t, &read)
+		if got, want := ascending(&read), ascending(b); !equal(got, want) {
+			t.Fatalf("ReadFrom decoded %v, want %v", got, want)
+		}
+	}
+}
+
+func TestReadFromRejectsBadInput(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := words(50).WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	for _, cut := range []int{0, 1, len(encoded) / 2, len(encoded) - 1} {
+		b := NewBTree[word]()
+		if _, err := b.ReadFrom(bytes.NewReader(encoded[:cut])); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("ReadFrom of %d of %d bytes returned %v, want %v", cut, len(encoded), err, io.ErrUnexpectedEOF)
+		}
+	}
+
+	// Two keys, "b" and then "a", out of order.
+	b := NewBTree[word]()
+	if _, err := b.ReadFrom(bytes.NewReader([]byte{2, 1, 'b', 1, 'a'})); err == nil {
+		t.Fatal("ReadFrom accepted keys out of order")
+	}
+}
+
+func TestGobRoundTrip(t *testing.T) {
+	type snapshot struct {
+		Name string
+		Tree *BTree[key]
+	}
+	for _, size := range []int{0, 1, 5000} {
+		var (
+			b   = filled(Options[key]{Degree: 3}, size)
+			buf bytes.Buffer
+		)
+		if err := gob.NewEncoder(&buf).Encode(snapshot{"keys", b}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The tree is decoded into an unset field, as the zero BTree, which
+		// orders its keys by their Compare method.
+		var decoded snapshot
+		if err := gob.NewDecoder(&buf).Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Name != "keys" {
+			t.Fatalf("decoded name %q, want %q", decoded.Name, "keys")
+		}
+		mustHold(t, decoded.Tree, b.ToSlice())
+		if size > 0 && decoded.Tree.Height() > FromSortedSlice(b.ToSlice()).Height() {
+			t.Fatalf("decoded tree of %d keys is %d levels high", size, decoded.Tree.Height())
+		}
+
+		// A configured tree keeps its configuration, replacing its keys.
+		data, err := b.GobEncode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		into := filled(Options[key]{Degree: 4}, 10)
+		if err := into.GobDecode(data); err != nil {
+			t.Fatal(err)
+		}
+		mustHold(t, into, b.ToSlice())
+		into.Insert(key(size))
+		mustHold(t, into, span(0, size+1))
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode([]key{2, 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBTree[key]().GobDecode(buf.Bytes()); err == nil {
+		t.Fatal("GobDecode accepted keys out of order")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 5000} {
+		b := filled(Options[key]{Degree: 3}, size)
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size == 0 && string(data) != "[]" {
+			t.Fatalf("an empty tree is encoded as %s, want []", data)
+		}
+		if size == 1 && string(data) != "[0]" {
+			t.Fatalf("a tree of one key is encoded as %s, want [0]", data)
+		}
+
+		var decoded *BTree[key]
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		mustHold(t, decoded, span(0, size))
+		into := filled(Options[key]{Degree: 4}, 10)
+		if err := json.Unmarshal(data, into); err != nil {
+			t.Fatal(err)
+		}
+		mustHold(t, into, span(0, size))
+	}
+
+	into := NewBTree[key]()
+	if err := json.Unmarshal([]byte("[3, 1, 2]"), into); err == nil {
+		t.Fatal("UnmarshalJSON accepted keys out of order")
+	}
+	if err := json.Unmarshal([]byte(`{"keys": []}`), into); err == nil {
+		t.Fatal("UnmarshalJSON accepted an object")
+	}
+}
+
+func TestWriteMerged(t *testing.T) {
+	var (
+		a = NewBTreeWithOptions(Options[tagged]{Degree: 3})
+		b = NewBTreeWithOptions(Options[tagged]{Degree: 3})
+	)
+	for i := 0; i < 300; i += 2 {
+		a.Insert(tagged{key(i), 1})
+	}
+	for i := 0; i < 300; i += 3 {
+		b.Insert(tagged{key(i), 2})
+	}
+	var written []tagged
+	enc := func(_ io.Writer, k tagged) error {
+		written = append(written, k)
+		return nil
+	}
+	if err := WriteMerged(io.Discard, a, b, enc); err != nil {
+		t.Fatal(err)
+	}
+	var want []tagged
+	for i := 0; i < 300; i++ {
+		switch {
+		case i%2 == 0:
+			want = append(want, tagged{key(i), 1})
+		case i%3 == 0:
+			want = append(want, tagged{key(i), 2})
+		}
+	}
+	if !equal(written, want) {
+		t.Fatalf("WriteMerged wrote %v, want %v", written, want)
+	}
+
+	// The first error from enc stops the walk, and is returned.
+	errFull := errors.New("full")
+	written = written[:0]
+	err := WriteMerged(io.Discard, a, b, func(w io.Writer, k tagged) error {
+		if len(written) == 10 {
+			return errFull
+		}
+		return enc(w, k)
+	})
+	if err != errFull || !equal(written, want[:10]) {
+		t.Fatalf("WriteMerged returned %v after writing %d keys, want %v after 10", err, len(written), errFull)
+	}
+}
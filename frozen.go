@@ -0,0 +1,61 @@
+package btree
+
+// FrozenIndex is an immutable copy of the keys of a BTree, held in a single
+// sorted slice rather than in nodes. Searches binary search the slice directly,
+// touching contiguous memory without allocating or following pointers between
+// nodes, at the cost of the index never changing once built. An index may be
+// read from separate goroutines at once.
+type FrozenIndex[T any] struct {
+	keys list[T]
+	cfg  *config[T]
+}
+
+// ToFrozenIndex returns a FrozenIndex holding the keys of the tree, built from
+// a single walk of the tree in ascending order. The index shares nothing with
+// the tree, taking a copy of its configuration for its ordering, so the tree
+// may go on to be changed freely.
+func (b BTree[T]) ToFrozenIndex() *FrozenIndex[T] {
+	keys := newList[T](b.size)
+	b.root.ascend(func(k T) bool {
+		keys = append(keys, k)
+		return true
+	})
+	cfg := *b.cfg
+	return &FrozenIndex[T]{keys, &cfg}
+}
+
+// Len returns the number of keys in the index.
+func (f *FrozenIndex[T]) Len() int {
+	return len(f.keys)
+}
+
+// Search searches the index for the value matching key if such a value exists.
+func (f *FrozenIndex[T]) Search(key T) (T, bool) {
+	if i, found := f.cfg.probe(f.keys, nil, key); found {
+		return f.keys[i], true
+	}
+	var zero T
+	return zero, false
+}
+
+// Ascend calls fn for each key in the index in ascending order, until fn
+// returns false.
+func (f *FrozenIndex[T]) Ascend(fn func(T) bool) {
+	for _, k := range f.keys {
+		if !fn(k) {
+			return
+		}
+	}
+}
+
+// AscendRange calls fn for each key k in the range from ≤ k < to in ascending
+// order, until fn returns false. The first key of the range is found by a
+// single binary search.
+func (f *FrozenIndex[T]) AscendRange(from, to T, fn func(T) bool) {
+	i, _ := find(f.keys, from, f.cfg.lower)
+	for _, k := range f.keys[i:] {
+		if f.cfg.compare(k, to) >= 0 || !fn(k) {
+			return
+		}
+	}
+}
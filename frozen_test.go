@@ -0,0 +1,74 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestFrozenIndex(t *testing.T) {
+	b := NewBTreeWithDegree[key](3)
+	for i := 0; i < 500; i++ {
+		b.Insert(key(i * 2))
+	}
+	f := b.ToFrozenIndex()
+	want := ascending(b)
+
+	// Changing the tree, even its order, leaves the index as it was.
+	b.Reverse()
+	for i := 0; i < 500; i++ {
+		b.Insert(key(i*2 + 1))
+	}
+
+	if f.Len() != len(want) {
+		t.Fatalf("index holds %d keys, want %d", f.Len(), len(want))
+	}
+	var got []key
+	f.Ascend(func(k key) bool {
+		got = append(got, k)
+		return true
+	})
+	if !equal(got, want) {
+		t.Fatalf("index walked %v, want %v", got, want)
+	}
+	for k := key(-1); k <= 1000; k++ {
+		if v, found := f.Search(k); found != (k >= 0 && k%2 == 0 && k < 1000) || (found && v != k) {
+			t.Fatalf("Search(%d) = %v, %v", k, v, found)
+		}
+	}
+	got = got[:0]
+	f.AscendRange(101, 111, func(k key) bool {
+		got = append(got, k)
+		return true
+	})
+	if want := []key{102, 104, 106, 108, 110}; !equal(got, want) {
+		t.Fatalf("AscendRange(101, 111) visited %v, want %v", got, want)
+	}
+}
+
+// benchmarkSearch times search for random keys from 0 to 399,999, half of
+// which are held by the keys searched.
+func benchmarkSearch(b *testing.B, search func(key) (key, bool)) {
+	r := rand.New(rand.NewSource(9))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		search(key(r.Intn(400000)))
+	}
+}
+
+// searched returns a tree of degree 32 holding the even keys from 0 to
+// 399,998.
+func searched() *BTree[key] {
+	tree := NewBTreeWithDegree[key](32)
+	for i := 0; i < 200000; i++ {
+		tree.Insert(key(i * 2))
+	}
+	return tree
+}
+
+func BenchmarkFrozenIndexSearch(b *testing.B) {
+	benchmarkSearch(b, searched().ToFrozenIndex().Search)
+}
+
+func BenchmarkBTreeSearch(b *testing.B) {
+	benchmarkSearch(b, searched().Search)
+}
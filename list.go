@@ -44,7 +44,13 @@ func (l *list[T]) remove(i int) T {
 	return l.removeFrom(i, i+1)[0]
 }
 
-func find[T Comparable[T]](l list[T], item T) (int, bool) {
+func (l list[T]) reverse() {
+	for i, j := 0, len(l)-1; i < j; i, j = i+1, j-1 {
+		l[i], l[j] = l[j], l[i]
+	}
+}
+
+func find[T any](l list[T], item T, compare func(a, b T) int) (int, bool) {
 	var (
 		low  = 0
 		high = len(l)
@@ -52,7 +58,7 @@ func find[T Comparable[T]](l list[T], item T) (int, bool) {
 	for low < high {
 		var (
 			between  = (low + high) / 2
-			compared = item.Compare(l[between])
+			compared = compare(item, l[between])
 		)
 		if compared < 0 {
 			high = between
@@ -66,3 +72,127 @@ func find[T Comparable[T]](l list[T], item T) (int, bool) {
 	}
 	return low, false
 }
+
+// item is a key together with the sequence number it was inserted with, which
+// travel together as keys move between nodes. seq is zero, and ignored, unless
+// the tree records sequence numbers.
+type item[T any] struct {
+	key T
+	seq uint64
+}
+
+// nodeKeys holds the keys of a node in order. When the tree records sequence
+// numbers, seqs holds the sequence number of each key at the same position as
+// the key, and is maintained alongside keys by the methods below. Otherwise,
+// seqs is nil. Likewise, when the tree weighs its keys with weigh, weight is
+// the total weight of keys, kept up to date by the same methods.
+type nodeKeys[T any] struct {
+	keys   list[T]
+	seqs   list[uint64]
+	weigh  func(T) int
+	weight int
+}
+
+func newNodeKeys[T any](capacity int, sequence bool, weigh func(T) int) nodeKeys[T] {
+	n := nodeKeys[T]{keys: newList[T](capacity), weigh: weigh}
+	if sequence {
+		n.seqs = newList[uint64](capacity)
+	}
+	return n
+}
+
+func (n nodeKeys[T]) at(i int) item[T] {
+	it := item[T]{key: n.keys[i]}
+	if n.seqs != nil {
+		it.seq = n.seqs[i]
+	}
+	return it
+}
+
+func (n *nodeKeys[T]) set(i int, it item[T]) {
+	n.put(i, it.key)
+	if n.seqs != nil {
+		n.seqs[i] = it.seq
+	}
+}
+
+// put replaces the i-th key with k, which keeps the sequence number of the key
+// it replaces.
+func (n *nodeKeys[T]) put(i int, k T) {
+	if n.weigh != nil {
+		n.weight += n.weigh(k) - n.weigh(n.keys[i])
+	}
+	n.keys[i] = k
+}
+
+// weightOf returns the weight of k, or 0 if the tree doesn't weigh its keys.
+func (n nodeKeys[T]) weightOf(k T) int {
+	if n.weigh == nil {
+		return 0
+	}
+	return n.weigh(k)
+}
+
+func (n *nodeKeys[T]) insertAt(i int, it item[T]) {
+	n.keys.insert(i, it.key)
+	if n.seqs != nil {
+		n.seqs.insert(i, it.seq)
+	}
+	if n.weigh != nil {
+		n.weight += n.weigh(it.key)
+	}
+}
+
+func (n *nodeKeys[T]) removeAt(i int) item[T] {
+	it := n.at(i)
+	n.keys.remove(i)
+	if n.seqs != nil {
+		n.seqs.remove(i)
+	}
+	if n.weigh != nil {
+		n.weight -= n.weigh(it.key)
+	}
+	return it
+}
+
+func (n *nodeKeys[T]) reverse() {
+	n.keys.reverse()
+	n.seqs.reverse()
+}
+
+func (n *nodeKeys[T]) spliceAt(i, j int, m *nodeKeys[T]) {
+	if n.weigh != nil {
+		for _, k := range m.keys[j:] {
+			moved := n.weigh(k)
+			n.weight += moved
+			m.weight -= moved
+		}
+	}
+	n.keys.splice(i, j, &m.keys)
+	if n.seqs != nil {
+		n.seqs.splice(i, j, &m.seqs)
+	}
+}
+
+// reset empties n, clearing the keys it held so that they're no longer kept
+// alive by its list.
+func (n *nodeKeys[T]) reset() {
+	clear(n.keys[:cap(n.keys)])
+	n.keys = n.keys[:0]
+	if n.seqs != nil {
+		n.seqs = n.seqs[:0]
+	}
+	n.weight = 0
+}
+
+// clone returns a copy of n with lists of its own, each with room for capacity
+// items, so that either can be changed without affecting the other.
+func (n nodeKeys[T]) clone(capacity int) nodeKeys[T] {
+	c := newNodeKeys[T](capacity, n.seqs != nil, n.weigh)
+	c.keys = append(c.keys, n.keys...)
+	if n.seqs != nil {
+		c.seqs = append(c.seqs, n.seqs...)
+	}
+	c.weight = n.weight
+	return c
+}
@@ -0,0 +1,58 @@
+package btree
+
+import "iter"
+
+// Map is an ordered map from keys of type K to values of type V, held in a
+// BTree of entries ordered by their keys alone. Each key maps to at most one
+// value, and the entries are walked in ascending order of their keys.
+type Map[K Comparable[K], V any] struct {
+	tree *BTree[entry[K, V]]
+}
+
+// entry is a key of a Map along with the value it maps to, compared by its key
+// alone so that an entry holding only a key finds that holding its value.
+type entry[K Comparable[K], V any] struct {
+	key   K
+	value V
+}
+
+func (e entry[K, V]) Compare(other entry[K, V]) int {
+	return e.key.Compare(other.key)
+}
+
+// NewMap creates an empty Map.
+func NewMap[K Comparable[K], V any]() *Map[K, V] {
+	return &Map[K, V]{tree: NewBTree[entry[K, V]]()}
+}
+
+// Len returns the number of entries in the map.
+func (m *Map[K, V]) Len() int {
+	return m.tree.Len()
+}
+
+// Get returns the value k maps to, or false if the map holds no entry for k.
+func (m *Map[K, V]) Get(k K) (V, bool) {
+	e, found := m.tree.Search(entry[K, V]{key: k})
+	return e.value, found
+}
+
+// Put maps k to v, replacing any value k already maps to.
+func (m *Map[K, V]) Put(k K, v V) {
+	m.tree.Insert(entry[K, V]{k, v})
+}
+
+// Delete removes the entry for k from the map if there is one.
+func (m *Map[K, V]) Delete(k K) {
+	m.tree.Remove(entry[K, V]{key: k})
+}
+
+// All returns an iterator over the entries of the map in ascending order of
+// their keys, for use with range. Breaking out of the loop stops the walk of
+// the tree.
+func (m *Map[K, V]) All() iter.Seq2[K, V] {
+	return func(yield func(K, V) bool) {
+		m.tree.root.ascend(func(e entry[K, V]) bool {
+			return yield(e.key, e.value)
+		})
+	}
+}
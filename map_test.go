@@ -0,0 +1,52 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	var (
+		m    = NewMap[key, int]()
+		want = make(map[key]int)
+		r    = rand.New(rand.NewSource(11))
+	)
+	for i := 0; i < 3000; i++ {
+		k := key(r.Intn(500))
+		if r.Intn(4) == 0 {
+			m.Delete(k)
+			delete(want, k)
+		} else {
+			m.Put(k, i)
+			want[k] = i
+		}
+	}
+	if m.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", m.Len(), len(want))
+	}
+	for k := key(0); k < 500; k++ {
+		v, found := m.Get(k)
+		if w, ok := want[k]; found != ok || v != w {
+			t.Fatalf("Get(%v) = %d, %t, want %d, %t", k, v, found, w, ok)
+		}
+	}
+
+	prev, n := key(-1), 0
+	for k, v := range m.All() {
+		if k <= prev || want[k] != v {
+			t.Fatalf("All yielded %v: %d after %v", k, v, prev)
+		}
+		prev, n = k, n+1
+	}
+	if n != len(want) {
+		t.Fatalf("All yielded %d entries, want %d", n, len(want))
+	}
+	n = 0
+	m.All()(func(key, int) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Fatalf("All yielded %d entries, want 3 before yield returned false", n)
+	}
+}
@@ -0,0 +1,35 @@
+package btree
+
+import "unsafe"
+
+// ApproxMemoryBytes estimates the memory held by the nodes of the tree, to
+// weigh the cost of one branching factor against another. Each node counts the
+// size of its own struct, along with the full capacity of the lists holding
+// its keys, their sequence numbers and its children, which are allocated with
+// room for 2t-1 keys however many they hold. Keys are counted at the size of T
+// alone, so memory they point to, such as the bytes of a string, isn't
+// counted. The whole tree is walked, taking O(n/t) time.
+func (b BTree[T]) ApproxMemoryBytes() int64 {
+	var (
+		key   T
+		child childNode[T]
+		total int64
+		walk  func(n node[T])
+	)
+	walk = func(n node[T]) {
+		keys, children := n.contents()
+		if children == nil {
+			total += int64(unsafe.Sizeof(childLeafNode[T]{}))
+		} else {
+			total += int64(unsafe.Sizeof(childInternalNode[T]{}))
+		}
+		total += int64(cap(keys)) * int64(unsafe.Sizeof(key))
+		total += int64(cap(seqsOf[T](n))) * int64(unsafe.Sizeof(uint64(0)))
+		total += int64(cap(children)) * int64(unsafe.Sizeof(child))
+		for _, c := range children {
+			walk(c)
+		}
+	}
+	walk(b.root)
+	return total
+}
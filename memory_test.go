@@ -0,0 +1,48 @@
+package btree
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestApproxMemoryBytes(t *testing.T) {
+	var (
+		b     = NewBTreeWithOptions(Options[key]{Degree: 3})
+		empty = b.ApproxMemoryBytes()
+		size  = int64(unsafe.Sizeof(key(0)))
+	)
+	if empty < int64(unsafe.Sizeof(childLeafNode[key]{})) {
+		t.Fatalf("an empty tree takes %d bytes, less than a leaf", empty)
+	}
+
+	// Every node has room for 2t-1 keys, and holds at least t-1 of them but
+	// for the root, bounding the memory taken by the keys either way.
+	for i := 0; i < 10000; i++ {
+		b.Insert(key(i))
+	}
+	used := b.ApproxMemoryBytes()
+	if used < 10000*size || used > 10000*size*100 {
+		t.Fatalf("a tree of 10000 keys takes %d bytes", used)
+	}
+	if used < int64(nodes(b))*5*size {
+		t.Fatalf("a tree of %d nodes takes %d bytes, less than room for their keys", nodes(b), used)
+	}
+
+	// Sequence numbers take room of their own, while the nodes merged away as
+	// keys are removed no longer count.
+	s := NewBTreeWithOptions(Options[key]{Degree: 3, Sequence: true})
+	for i := 0; i < 10000; i++ {
+		s.Insert(key(i))
+	}
+	if got := s.ApproxMemoryBytes(); got <= used {
+		t.Fatalf("a tree with sequence numbers takes %d bytes, no more than %d without", got, used)
+	}
+	for i := 0; i < 10000; i++ {
+		if i%8 != 0 {
+			b.Remove(key(i))
+		}
+	}
+	if got := b.ApproxMemoryBytes(); got >= used {
+		t.Fatalf("a tree with most of its keys removed takes %d bytes, from %d", got, used)
+	}
+}
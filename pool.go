@@ -0,0 +1,51 @@
+package btree
+
+import "sync"
+
+// nodePool holds the nodes of a tree created with PoolNodes which have been
+// merged away, so that they, along with the lists for their keys and children,
+// may be taken up again by nodes created afterwards rather than allocated
+// anew. Configurations copied from one another share a pool, as their nodes
+// are all alike in size.
+type nodePool[T any] struct {
+	leaves    sync.Pool
+	internals sync.Pool
+}
+
+// leaf returns a pooled leaf node for the tree configured with cfg, or nil if
+// the pool is empty.
+func (p *nodePool[T]) leaf(cfg *config[T]) *childLeafNode[T] {
+	n, _ := p.leaves.Get().(*childLeafNode[T])
+	if n != nil {
+		n.cfg = cfg
+	}
+	return n
+}
+
+// internal returns a pooled internal node for the tree configured with cfg, or
+// nil if the pool is empty.
+func (p *nodePool[T]) internal(cfg *config[T]) *childInternalNode[T] {
+	n, _ := p.internals.Get().(*childInternalNode[T])
+	if n != nil {
+		n.cfg = cfg
+	}
+	return n
+}
+
+// putLeaf empties the leaf node n and returns it to the pool. Nothing may refer
+// to n, or to its lists, once it has been returned.
+func (p *nodePool[T]) putLeaf(n *childLeafNode[T]) {
+	n.nodeKeys.reset()
+	n.cfg, n.prev, n.next = nil, nil, nil
+	p.leaves.Put(n)
+}
+
+// putInternal empties the internal node n and returns it to the pool, in the
+// same way as putLeaf.
+func (p *nodePool[T]) putInternal(n *childInternalNode[T]) {
+	n.nodeKeys.reset()
+	clear(n.children[:cap(n.children)])
+	n.children = n.children[:0]
+	n.cfg, n.count, n.total = nil, 0, 0
+	p.internals.Put(n)
+}
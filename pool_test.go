@@ -0,0 +1,53 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestPoolNodes(t *testing.T) {
+	var (
+		pooled = NewBTreeWithOptions(Options[key]{Degree: 3, PoolNodes: true})
+		plain  = NewBTreeWithOptions(Options[key]{Degree: 3})
+		r      = rand.New(rand.NewSource(9))
+	)
+	for i := 0; i < 20000; i++ {
+		k := key(r.Intn(2000))
+		if r.Intn(2) == 0 {
+			pooled.Insert(k)
+			plain.Insert(k)
+		} else {
+			pooled.Remove(k)
+			plain.Remove(k)
+		}
+		if i%1000 == 0 {
+			mustHold(t, pooled, ascending(plain))
+		}
+	}
+	mustHold(t, pooled, ascending(plain))
+}
+
+// benchmarkChurn times a sliding window over a tree of 10,000 keys of degree
+// 8, inserting a new key and removing the oldest, so that nodes are steadily
+// split and merged away.
+func benchmarkChurn(b *testing.B, opts Options[key]) {
+	opts.Degree = 8
+	tree := NewBTreeWithOptions(opts)
+	for i := 0; i < 10000; i++ {
+		tree.Insert(key(i))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tree.Insert(key(10000 + i))
+		tree.Remove(key(i))
+	}
+}
+
+func BenchmarkChurn(b *testing.B) {
+	benchmarkChurn(b, Options[key]{})
+}
+
+func BenchmarkChurnPoolNodes(b *testing.B) {
+	benchmarkChurn(b, Options[key]{PoolNodes: true})
+}
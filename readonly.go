@@ -0,0 +1,88 @@
+package btree
+
+// ReadOnly is a read-only view of a subtree of a BTree, sharing the nodes of
+// the tree without copying them. A view remains valid only until the tree it
+// was taken from is next changed, after which it must no longer be used.
+// Views may be read from separate goroutines at once, so long as the tree
+// isn't changed meanwhile.
+type ReadOnly[T any] struct {
+	root node[T]
+	cfg  *config[T]
+}
+
+// Subtrees returns views of each of the subtrees rooted at the children of the
+// root of the tree, in ascending order, so that work over the whole tree can
+// be divided between goroutines. The keys of the root itself, which separate
+// the subtrees, belong to none of the views. If the root has no children, the
+// tree is returned as a single view.
+func (b BTree[T]) Subtrees() []ReadOnly[T] {
+	_, children := b.root.contents()
+	if children == nil {
+		return []ReadOnly[T]{{b.root, b.cfg}}
+	}
+	views := make([]ReadOnly[T], len(children))
+	for i, child := range children {
+		views[i] = ReadOnly[T]{child, b.cfg}
+	}
+	return views
+}
+
+// Len returns the number of keys in the subtree, which are counted in O(n)
+// time unless the tree keeps order statistics.
+func (v ReadOnly[T]) Len() int {
+	return v.root.size()
+}
+
+// Search searches the subtree for the value matching key if such a value
+// exists.
+func (v ReadOnly[T]) Search(key T) (T, bool) {
+	return v.root.search(key)
+}
+
+// Ascend calls fn for each key in the subtree in ascending order, until fn
+// returns false.
+func (v ReadOnly[T]) Ascend(fn func(T) bool) {
+	v.root.ascend(fn)
+}
+
+// Min returns the smallest key in the subtree if the subtree isn't empty.
+func (v ReadOnly[T]) Min() (key T, found bool) {
+	if numKeys[T](v.root) > 0 {
+		return v.root.min(), true
+	}
+	return
+}
+
+// Max returns the largest key in the subtree if the subtree isn't empty.
+func (v ReadOnly[T]) Max() (key T, found bool) {
+	if numKeys[T](v.root) > 0 {
+		return v.root.max(), true
+	}
+	return
+}
+
+// Validate checks that the subtree satisfies the invariants of a B-Tree in the
+// same way as Validate on the tree, so that the subtrees of a tree can be
+// validated on separate goroutines. The root of the subtree is held to the
+// same bounds on its number of keys as any other node but the root of the
+// tree, while the keys of the tree separating the subtrees, and the links
+// between the leaves of neighbouring subtrees, are left unchecked. The whole
+// subtree is walked, taking O(n) time.
+func (v ReadOnly[T]) Validate() error {
+	_, root := v.root.(*rootLeafNode[T])
+	val := validator[T]{cfg: v.cfg, depth: -1}
+	if v.cfg.linkLeaves {
+		n := v.root
+		for {
+			_, children := n.contents()
+			if children == nil {
+				break
+			}
+			n = children[0]
+		}
+		if leaf, ok := n.(*childLeafNode[T]); ok {
+			val.last = leaf.prev
+		}
+	}
+	return val.validate(v.root, nil, nil, 0, root)
+}
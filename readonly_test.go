@@ -0,0 +1,58 @@
+package btree
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestSubtreesValidate(t *testing.T) {
+	for _, opts := range []Options[key]{
+		{Degree: 3},
+		{Degree: 3, LinkLeaves: true},
+		{Degree: 3, OrderStatistics: true, Weight: func(k key) int { return int(k) % 4 }},
+	} {
+		b := filled(opts, 2000)
+		views := b.Subtrees()
+		errs := make([]error, len(views))
+		var wg sync.WaitGroup
+		for i, v := range views {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs[i] = v.Validate()
+			}()
+		}
+		wg.Wait()
+		for i, err := range errs {
+			if err != nil {
+				t.Fatalf("subtree %d of a tree with %+v: %v", i, opts, err)
+			}
+		}
+
+		// Keys swapped out of order within a leaf of the last subtree are
+		// found by its view alone.
+		n := views[len(views)-1].root
+		for {
+			_, children := n.contents()
+			if children == nil {
+				break
+			}
+			n = children[0]
+		}
+		keys, _ := n.contents()
+		keys[0], keys[1] = keys[1], keys[0]
+		if err := views[len(views)-1].Validate(); err == nil {
+			t.Fatalf("the view of a subtree with keys out of order validated")
+		}
+		if err := views[0].Validate(); err != nil {
+			t.Fatalf("the view of an untouched subtree failed to validate: %v", err)
+		}
+	}
+
+	// A tree whose root is a leaf is its own single view, held to the bounds of
+	// a root.
+	b := filled(Options[key]{Degree: 3}, 1)
+	if views := b.Subtrees(); len(views) != 1 || views[0].Validate() != nil {
+		t.Fatalf("a tree of one key gave %d views, or one which failed to validate", len(views))
+	}
+}
@@ -0,0 +1,49 @@
+package btree
+
+import "sync"
+
+// recency records the keys most recently found by Search, for trees created
+// with RecentAccesses. The keys are distinct, ordered from the least to the
+// most recently found, and number at most capacity. As Search may be called by
+// several readers at once, the keys are guarded by a mutex of their own.
+type recency[T any] struct {
+	mu       sync.Mutex
+	keys     []T
+	capacity int
+}
+
+func newRecency[T any](capacity int) *recency[T] {
+	return &recency[T]{keys: make([]T, 0, capacity), capacity: capacity}
+}
+
+// record moves k to the end of the recorded keys, dropping the least recently
+// found key if there's no room left for k. Any key comparing equal to k is
+// found by a linear scan of the recorded keys.
+func (r *recency[T]) record(k T, compare func(a, b T) int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, o := range r.keys {
+		if compare(o, k) == 0 {
+			r.keys = append(r.keys[:i], r.keys[i+1:]...)
+			break
+		}
+	}
+	if len(r.keys) == r.capacity {
+		r.keys = append(r.keys[:0], r.keys[1:]...)
+	}
+	r.keys = append(r.keys, k)
+}
+
+// recent returns up to n of the recorded keys, most recently found first.
+func (r *recency[T]) recent(n int) []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if n > len(r.keys) {
+		n = len(r.keys)
+	}
+	keys := make([]T, 0, n)
+	for i := len(r.keys) - 1; len(keys) < n; i-- {
+		keys = append(keys, r.keys[i])
+	}
+	return keys
+}
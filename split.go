@@ -0,0 +1,268 @@
+package btree
+
+// The functions here cut a tree in two and join trees back together, working
+// on subtrees which, like the root of a tree, may hold too few keys to be
+// valid children. Such a subtree is held as a childNode regardless, so that it
+// can be attached beneath other nodes once it has been joined with others
+// large enough to make it valid.
+
+// splitAt splits the subtree rooted at n about its i-th key in order, so that
+// the first subtree returned holds the i keys before it, and the second the
+// remaining keys. The path from n down to the position of the split separates
+// those children and keys of each node on the path which belong to either
+// side. At each level, these are joined with the two subtrees split from the
+// level below.
+//
+// Here, a tree is split before its 5th key E. The path to E passes through the
+// child to the right of D, so the keys and children to the left of D, and those
+// to the right of H, are joined with the subtrees either side of the split made
+// further down:
+//
+//	         (D       H)
+//	        ↓     ↓     ↓
+//	  (B)      (F)      (J)
+//	  ↓ ↓      ↓ ↓      ↓ ↓
+//	(A) (C)  (E) (G)  (I) (K)
+//
+// Splitting the subtree rooted at (F) before E leaves nothing to its left, and
+// (E F G) to its right. (A B C) is joined with the empty subtree around D,
+// while (E F G) is joined with (J) around H:
+//
+//	  (B)          (H     J)
+//	  ↓ ↓         ↓     ↓   ↓
+//	(A) (C D)  (E F G) (I) (K)
+func splitAt[T any](n childNode[T], i int) (left, right childNode[T]) {
+	switch n := n.(type) {
+	case *childLeafNode[T]:
+		sibling := newChildLeafNode(n.cfg)
+		sibling.spliceAt(0, i, &n.nodeKeys)
+		if n.cfg.linkLeaves {
+			sibling.prev, sibling.next = n, n.next
+			if n.next != nil {
+				n.next.prev = sibling
+			}
+			n.next = sibling
+		}
+		return n, sibling
+	case *childInternalNode[T]:
+		j := 0
+		for ; j < len(n.keys); j++ {
+			size := n.children[j].size()
+			if i <= size {
+				break
+			}
+			i -= size + 1
+		}
+		cl, cr := splitAt(n.mutableChild(j), i)
+
+		// n keeps the keys and children to the left of the split child, and
+		// rest takes those to the right of it.
+		rest := newChildInternalNode(n.cfg)
+		rest.spliceAt(0, j, &n.nodeKeys)
+		rest.children.splice(0, j+1, &n.children)
+		n.children.remove(j)
+
+		left = cl
+		if j > 0 {
+			median := n.removeAt(j - 1)
+			n.recount()
+			left = join(n.cfg, collapse[T](n), median, cl)
+		}
+		right = cr
+		if len(rest.keys) > 0 {
+			median := rest.removeAt(0)
+			rest.recount()
+			right = join(n.cfg, cr, median, collapse[T](rest))
+		}
+		return left, right
+	}
+	panic("unreachable")
+}
+
+// join joins the subtrees l and r around median, where every key in l is less
+// than median and every key in r is greater than it, returning the root of the
+// joined subtree. Either of l or r may be empty.
+//
+// If l and r are of the same height, they are merged about median, or made the
+// children of a new node with median as its only key. Otherwise, the
+// shorter of the two is attached beside the node at the same height along the
+// inner spine of the taller one, which is descended in a single pass like an
+// insertion, by splitting any full nodes on the way down.
+//
+// Here, (X) is joined to the right of a taller tree around W, where nodes hold
+// at most 3 keys. (X) becomes the last child of (P), the node above the height
+// of (X) along the rightmost spine of the tree:
+//
+//	     (P)                    (P           W)
+//	    ↓   ↓              →   ↓     ↓          ↓
+//	(B D)   (R T V)  W  (X)  (B D)   (R T V)    (X)
+//
+// (X) holds enough keys to be a child. Had it held too few, it would then have
+// been merged with its new sibling, or given keys from it.
+func join[T any](cfg *config[T], l childNode[T], median item[T], r childNode[T]) childNode[T] {
+	l, r = l.mutable(cfg), r.mutable(cfg)
+	if numKeys[T](l) == 0 {
+		return insertInto(cfg, r, median)
+	}
+	if numKeys[T](r) == 0 {
+		return insertInto(cfg, l, median)
+	}
+	if cfg.linkLeaves {
+		last, first := l.lastLeaf(), r.firstLeaf()
+		last.next, first.prev = first, last
+	}
+
+	lh, rh := height[T](l), height[T](r)
+	switch {
+	case lh > rh:
+		if !l.isBelowMax() {
+			l = above(cfg, l)
+			lh++
+		}
+		l.(*childInternalNode[T]).joinRight(lh, median, r, rh)
+		return l
+	case lh < rh:
+		if !r.isBelowMax() {
+			r = above(cfg, r)
+			rh++
+		}
+		r.(*childInternalNode[T]).joinLeft(rh, median, l, lh)
+		return r
+	}
+
+	if numKeys[T](l)+numKeys[T](r) < 2*cfg.t-1 {
+		l.merge(median, r)
+		return l
+	}
+	for l.isBelowMin() {
+		median = l.shuffleLeft(median, r)
+	}
+	for r.isBelowMin() {
+		median = r.shuffleRight(median, l)
+	}
+	parent := newChildInternalNode(cfg)
+	parent.insertAt(0, median)
+	parent.children.insert(0, l)
+	parent.children.insert(1, r)
+	parent.recount()
+	return parent
+}
+
+// joinRight attaches the subtree r, of height rh, after median as the last
+// child of the node at height rh+1 along the rightmost spine of the subtree
+// rooted at the non-full node n, of height h.
+func (n *childInternalNode[T]) joinRight(h int, median item[T], r childNode[T], rh int) {
+	for ; h > rh+1; h-- {
+		n.grew(median.key, r)
+		last := n.mutableChild(len(n.keys))
+		if !last.isBelowMax() {
+			promoted, sibling := last.split()
+			n.insertAt(len(n.keys), promoted)
+			n.children.insert(len(n.children), sibling)
+			last = sibling
+		}
+		n = last.(*childInternalNode[T])
+	}
+	n.grew(median.key, r)
+	n.insertAt(len(n.keys), median)
+	n.children.insert(len(n.children), r)
+	if !r.isBelowMin() {
+		return
+	}
+
+	// r is too small to be a child, so is merged with its new left sibling or,
+	// if they're too big to merge, takes keys from it.
+	var (
+		i       = len(n.keys)
+		sibling = n.mutableChild(i - 1)
+	)
+	if numKeys[T](sibling)+numKeys[T](r) < 2*n.cfg.t-1 {
+		sibling.merge(n.removeAt(i-1), r)
+		n.children.remove(i)
+		return
+	}
+	for r.isBelowMin() {
+		n.set(i-1, r.shuffleRight(n.at(i-1), sibling))
+	}
+}
+
+// joinLeft attaches the subtree l, of height lh, before median as the first
+// child of the node at height lh+1 along the leftmost spine of the subtree
+// rooted at the non-full node n, of height h.
+func (n *childInternalNode[T]) joinLeft(h int, median item[T], l childNode[T], lh int) {
+	for ; h > lh+1; h-- {
+		n.grew(median.key, l)
+		first := n.mutableChild(0)
+		if !first.isBelowMax() {
+			promoted, sibling := first.split()
+			n.insertAt(0, promoted)
+			n.children.insert(1, sibling)
+		}
+		n = first.(*childInternalNode[T])
+	}
+	n.grew(median.key, l)
+	n.insertAt(0, median)
+	n.children.insert(0, l)
+	if !l.isBelowMin() {
+		return
+	}
+
+	// Likewise, l is merged with or takes keys from its new right sibling.
+	sibling := n.mutableChild(1)
+	if numKeys[T](l)+numKeys[T](sibling) < 2*n.cfg.t-1 {
+		l.merge(n.removeAt(0), sibling)
+		n.children.remove(1)
+		return
+	}
+	for l.isBelowMin() {
+		n.set(0, l.shuffleLeft(n.at(0), sibling))
+	}
+}
+
+// above splits the full node n, returning a new node holding just the median
+// key with n and its new sibling as its children, in the same way as the root
+// of a tree grows.
+func above[T any](cfg *config[T], n childNode[T]) childNode[T] {
+	var (
+		parent          = newChildInternalNode(cfg)
+		median, sibling = n.split()
+	)
+	parent.insertAt(0, median)
+	parent.children.insert(0, n)
+	parent.children.insert(1, sibling)
+	parent.recount()
+	return parent
+}
+
+// insertInto inserts it into the subtree rooted at n, which may be full,
+// returning the root of the subtree after the insertion.
+func insertInto[T any](cfg *config[T], n childNode[T], it item[T]) childNode[T] {
+	if !n.isBelowMax() {
+		n = above(cfg, n)
+	}
+	n.insertBelowMax(it, true, nil)
+	return n
+}
+
+// collapse returns the only child of n if n is an internal node without any
+// keys, or n itself otherwise.
+func collapse[T any](n childNode[T]) childNode[T] {
+	if keys, children := n.contents(); len(keys) == 0 && children != nil {
+		return children[0]
+	}
+	return n
+}
+
+// height returns the number of levels in the subtree rooted at n below n.
+func height[T any](n node[T]) (h int) {
+	for _, children := n.contents(); children != nil; _, children = children[0].contents() {
+		h++
+	}
+	return
+}
+
+// numKeys returns the number of keys held by the node n itself.
+func numKeys[T any](n node[T]) int {
+	keys, _ := n.contents()
+	return len(keys)
+}
@@ -0,0 +1,213 @@
+package btree
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// filled returns a tree configured by opts holding the keys 0 to n-1, inserted
+// in a random order.
+func filled(opts Options[key], n int) *BTree[key] {
+	b := NewBTreeWithOptions(opts)
+	for _, i := range rand.New(rand.NewSource(int64(n))).Perm(n) {
+		b.Insert(key(i))
+	}
+	return b
+}
+
+// span returns the keys from lo up to but not including hi.
+func span(lo, hi int) []key {
+	keys := make([]key, 0, hi-lo)
+	for i := lo; i < hi; i++ {
+		keys = append(keys, key(i))
+	}
+	return keys
+}
+
+// mustHold fails the test unless b is valid and holds exactly want in order.
+func mustHold(t *testing.T, b *BTree[key], want []key) {
+	t.Helper()
+	mustValidate(t, b)
+	if got := ascending(b); !equal(got, want) {
+		t.Fatalf("tree holds %v, want %v", got, want)
+	}
+	if b.Len() != len(want) {
+		t.Fatalf("tree records %d keys, want %d", b.Len(), len(want))
+	}
+}
+
+func TestSplitTopK(t *testing.T) {
+	for _, opts := range []Options[key]{{Degree: 3}, {Degree: 3, LinkLeaves: true}} {
+		for _, k := range []int{-1, 0, 1, 100, 499, 500, 600} {
+			b := filled(opts, 500)
+			top := b.SplitTopK(k)
+			cut := min(max(500-k, 0), 500)
+			mustHold(t, b, span(0, cut))
+			mustHold(t, top, span(cut, 500))
+
+			b.Insert(key(1000))
+			top.Insert(key(1001))
+			mustHold(t, b, append(span(0, cut), 1000))
+			mustHold(t, top, append(span(cut, 500), 1001))
+		}
+	}
+}
+
+func TestSplitTopKLeavesClonesUnchanged(t *testing.T) {
+	b := filled(Options[key]{Degree: 3}, 500)
+	before := b.Clone()
+	top := b.SplitTopK(200)
+	after := top.Clone()
+	for i := 0; i < 500; i += 3 {
+		b.Remove(key(i))
+		top.Remove(key(i))
+	}
+	mustHold(t, before, span(0, 500))
+	mustHold(t, after, span(300, 500))
+}
+
+func TestSplitLeavesClonesUnchanged(t *testing.T) {
+	for _, opts := range []Options[key]{{Degree: 3, Sequence: true}, {Degree: 3, Sequence: true, Multiset: true}} {
+		var (
+			b           = filled(opts, 500)
+			left, right = b.Split(key(150))
+		)
+
+		// Sequence numbers carry on from those of b in each tree alone.
+		b.Insert(key(-2))
+		left.Insert(key(-1))
+		left.AscendWithSeq(func(seq uint64, k key) bool {
+			if seq != 500 {
+				t.Fatalf("%v was inserted into the left tree with %d, want 500", k, seq)
+			}
+			return false
+		})
+		b.Remove(key(-2))
+		left.Remove(key(-1))
+
+		l, r := left.Clone(), right.Clone()
+		mustHold(t, left, span(0, 150))
+		mustHold(t, right, span(150, 500))
+
+		// The emptied tree, and both halves, go on to change apart from one
+		// another and from the clones.
+		for i := 0; i < 500; i += 3 {
+			b.Insert(key(i))
+			left.Remove(key(i))
+			right.Remove(key(i))
+			right.Insert(key(1000 + i))
+		}
+		mustHold(t, l, span(0, 150))
+		mustHold(t, r, span(150, 500))
+		mustValidate(t, b)
+		mustValidate(t, left)
+		mustValidate(t, right)
+		if b.Len() != 167 || left.Len() != 100 || right.Len() != 400 {
+			t.Fatalf("trees hold %d, %d and %d keys, want 167, 100 and 400", b.Len(), left.Len(), right.Len())
+		}
+	}
+}
+
+func TestConcatLeavesClonesUnchanged(t *testing.T) {
+	// Cloning one half of a split, before joining the halves again, leaves the
+	// nodes of the other half holding the configuration of b.
+	b := filled(Options[key]{Degree: 3}, 300)
+	l, r := b.Split(key(100))
+	right := r.Clone()
+	j := Concat(l, r)
+	top := j.SplitTopK(50)
+	rest := top.Clone()
+	j = Concat(j, top)
+	l, r = j.Split(key(200))
+	left := l.Clone()
+	j = Concat(l, r)
+	empty := Concat(NewBTreeWithOptions(Options[key]{Degree: 3}), j.Clone())
+
+	for i := 0; i < 300; i += 2 {
+		j.Remove(key(i))
+		j.Insert(key(1000 + i))
+		empty.Remove(key(i + 1))
+	}
+	mustHold(t, right, span(100, 300))
+	mustHold(t, rest, span(250, 300))
+	mustHold(t, left, span(0, 200))
+	mustValidate(t, j)
+	mustValidate(t, empty)
+	if j.Len() != 300 || empty.Len() != 150 {
+		t.Fatalf("joined trees hold %d and %d keys, want 300 and 150", j.Len(), empty.Len())
+	}
+}
+
+func TestConcatLinksLeaves(t *testing.T) {
+	var (
+		opts = Options[key]{Degree: 3, LinkLeaves: true}
+		l    = filled(opts, 700)
+		r    = NewBTreeWithOptions(opts)
+	)
+	for i := 700; i < 1000; i++ {
+		r.Insert(key(i))
+	}
+	j := Concat(l, r)
+	for i := 0; i < 1000; i += 3 {
+		j.Remove(key(i))
+	}
+	var want, scanned []key
+	for i := 0; i < 1000; i++ {
+		if i%3 != 0 {
+			want = append(want, key(i))
+		}
+	}
+	mustHold(t, j, want)
+	j.ScanLeaves(func(k key) bool {
+		scanned = append(scanned, k)
+		return true
+	})
+	if !equal(scanned, want) {
+		t.Fatalf("ScanLeaves walked %v, want %v", scanned, want)
+	}
+}
+
+func TestConcatOfTreesConfiguredDifferently(t *testing.T) {
+	for _, opts := range []Options[key]{
+		{Degree: 4},
+		{Degree: 3, LinkLeaves: true},
+		{Degree: 3, Multiset: true},
+		{Degree: 3, OrderStatistics: true},
+		{Degree: 3, Weight: func(k key) int { return int(k) }},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("Concat didn't panic on a tree with %+v", opts)
+				}
+			}()
+			right := NewBTreeWithOptions(opts)
+			for i := 200; i < 300; i++ {
+				right.Insert(key(i))
+			}
+			Concat(filled(Options[key]{Degree: 3}, 200), right)
+		}()
+	}
+}
+
+func TestConcatKeepsWeights(t *testing.T) {
+	var (
+		opts = Options[key]{Degree: 3, Weight: func(k key) int { return int(k) % 3 }}
+		l    = filled(opts, 200)
+		r    = NewBTreeWithOptions(opts)
+	)
+	for i := 200; i < 500; i++ {
+		r.Insert(key(i))
+	}
+	j := Concat(l, r)
+	mustHold(t, j, span(0, 500))
+	sum := 0
+	for i := 0; i < 500; i++ {
+		if sum += i % 3; sum >= 169 {
+			if got, found := j.SelectByWeight(169, nil); !found || got != key(i) {
+				t.Fatalf("SelectByWeight(169) = %v, %v, want %v", got, found, i)
+			}
+			break
+		}
+	}
+}
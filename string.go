@@ -0,0 +1,56 @@
+package btree
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String renders the tree level by level for debugging, after the diagrams in
+// the comments of this package. Each level takes a line of its own, from the
+// root down to the leaves, indented by two spaces for each level above it, and
+// lists its nodes from left to right with the keys of each formatted with %v.
+// Internal nodes are written in parentheses and leaves in square brackets, so
+// that the tree below, of height 1, is rendered as:
+//
+//	(D H)
+//	  [A B C] [E F G] [I J K]
+//
+// An empty tree is rendered as a single empty leaf. The tree is walked breadth
+// first, taking O(n) time, and is only read.
+func (b BTree[T]) String() string {
+	var (
+		sb     strings.Builder
+		level  = []node[T]{b.root}
+		indent string
+	)
+	for len(level) > 0 {
+		var below []node[T]
+		if indent != "" {
+			sb.WriteByte('\n')
+		}
+		sb.WriteString(indent)
+		for i, n := range level {
+			keys, children := n.contents()
+			start, end := "[", "]"
+			if children != nil {
+				start, end = "(", ")"
+			}
+			if i > 0 {
+				sb.WriteByte(' ')
+			}
+			sb.WriteString(start)
+			for j, k := range keys {
+				if j > 0 {
+					sb.WriteByte(' ')
+				}
+				fmt.Fprint(&sb, k)
+			}
+			sb.WriteString(end)
+			for _, child := range children {
+				below = append(below, child)
+			}
+		}
+		level, indent = below, indent+"  "
+	}
+	return sb.String()
+}
@@ -0,0 +1,130 @@
+package btree
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Validate checks that the tree satisfies every invariant of a B-Tree,
+// returning an error describing the first violation found, or nil if there is
+// none. Each node must hold its keys in order, and every node but the root
+// between t-1 and 2t-1 keys. Each internal node must have one more child than
+// it has keys, with every key of each child lying between the keys either side
+// of the child, and all leaves must lie at the same depth. The counts of keys
+// held beneath each node must be correct if the tree keeps order statistics,
+// as must their total weights if the tree weighs its keys, and the chain of
+// leaves if the tree links them. The whole tree is walked, taking O(n) time.
+func (b BTree[T]) Validate() error {
+	v := validator[T]{cfg: b.cfg, depth: -1}
+	if err := v.validate(b.root, nil, nil, 0, true); err != nil {
+		return err
+	}
+	if size := b.root.size(); size != b.size {
+		return fmt.Errorf("btree: tree holds %d keys but records %d", size, b.size)
+	}
+	if b.cfg.linkLeaves && v.last != nil && v.last.next != nil {
+		return errors.New("btree: last leaf is linked to a following leaf")
+	}
+	return nil
+}
+
+// validator walks a tree in order to validate it, recording the depth of the
+// first leaf reached, against which all other leaves are checked, and the
+// last leaf visited, with which the next leaf must be linked.
+type validator[T any] struct {
+	cfg   *config[T]
+	depth int
+	last  *childLeafNode[T]
+}
+
+// validate checks the subtree rooted at n, at the given depth, every key of
+// which must lie between lo and hi where they're set.
+func (v *validator[T]) validate(n node[T], lo, hi *T, depth int, root bool) error {
+	keys, children := n.contents()
+	if len(keys) > 2*v.cfg.t-1 {
+		return fmt.Errorf("btree: node at depth %d holds %d keys, more than 2t-1", depth, len(keys))
+	}
+	switch {
+	case !root && len(keys) < v.cfg.t-1:
+		return fmt.Errorf("btree: node at depth %d holds %d keys, fewer than t-1", depth, len(keys))
+	case root && children != nil && len(keys) == 0:
+		return errors.New("btree: internal root holds no keys")
+	}
+	if v.cfg.sequence {
+		if seqs := seqsOf[T](n); len(seqs) != len(keys) {
+			return fmt.Errorf("btree: node at depth %d holds %d keys but %d sequence numbers", depth, len(keys), len(seqs))
+		}
+	}
+	weight := 0
+	if v.cfg.weight != nil {
+		for _, k := range keys {
+			weight += v.cfg.weight(k)
+		}
+		if own := keysOf(n).weight; own != weight {
+			return fmt.Errorf("btree: node at depth %d records a weight of %d for its keys, which weigh %d", depth, own, weight)
+		}
+	}
+	for i, k := range keys {
+		if i > 0 && !v.ordered(keys[i-1], k) {
+			return fmt.Errorf("btree: keys %d and %d of node at depth %d are out of order", i-1, i, depth)
+		}
+		if (lo != nil && !v.ordered(*lo, k)) || (hi != nil && !v.ordered(k, *hi)) {
+			return fmt.Errorf("btree: key %d of node at depth %d lies outside the keys either side of the node", i, depth)
+		}
+	}
+
+	if children == nil {
+		if v.depth < 0 {
+			v.depth = depth
+		} else if depth != v.depth {
+			return fmt.Errorf("btree: leaf at depth %d, while others are at depth %d", depth, v.depth)
+		}
+		if leaf, ok := n.(*childLeafNode[T]); ok && v.cfg.linkLeaves {
+			if leaf.prev != v.last || (v.last != nil && v.last.next != leaf) {
+				return fmt.Errorf("btree: leaf at depth %d isn't linked to the leaf before it", depth)
+			}
+			v.last = leaf
+		}
+		return nil
+	}
+
+	if len(children) != len(keys)+1 {
+		return fmt.Errorf("btree: node at depth %d has %d children for %d keys", depth, len(children), len(keys))
+	}
+	count := len(keys)
+	for i, child := range children {
+		clo, chi := lo, hi
+		if i > 0 {
+			clo = &keys[i-1]
+		}
+		if i < len(keys) {
+			chi = &keys[i]
+		}
+		if err := v.validate(child, clo, chi, depth+1, false); err != nil {
+			return err
+		}
+		if v.cfg.counted {
+			count += child.size()
+		}
+		weight += child.sum()
+	}
+	if size := n.size(); v.cfg.counted && size != count {
+		return fmt.Errorf("btree: node at depth %d records %d keys beneath it but holds %d", depth, size, count)
+	}
+	if sum := n.sum(); v.cfg.weight != nil && sum != weight {
+		return fmt.Errorf("btree: node at depth %d records a weight of %d beneath it but holds %d", depth, sum, weight)
+	}
+	return nil
+}
+
+// ordered reports whether a may precede b in the tree, where keys must
+// strictly ascend, unless the tree is a multiset.
+func (v *validator[T]) ordered(a, b T) bool {
+	compared := v.cfg.compare(a, b)
+	return compared < 0 || (compared == 0 && v.cfg.multiset)
+}
+
+// seqsOf returns the sequence numbers of the keys of the node n.
+func seqsOf[T any](n node[T]) list[uint64] {
+	return keysOf(n).seqs
+}